@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"stock-talk-service/internal/config"
+	"stock-talk-service/internal/db"
+	"stock-talk-service/internal/repositories"
+	"stock-talk-service/internal/services"
+	"text/tabwriter"
+	"time"
+)
+
+const apiKeyUsage = `usage: api apikey <command> [-config <file>] [flags]
+
+commands:
+  create -name <name> [-tier <tier>]   issue a key and print it once
+  list                                 list issued keys
+  revoke -id <id>                      revoke a key`
+
+// runAPIKey manages API keys against the configured database.
+func runAPIKey(args []string) error {
+	if len(args) == 0 {
+		return errors.New(apiKeyUsage)
+	}
+
+	fs := flag.NewFlagSet("apikey "+args[0], flag.ContinueOnError)
+	configOptions := config.BindFlags(fs)
+	var name, tier, id *string
+	switch args[0] {
+	case "create":
+		name = fs.String("name", "", "who or what the key is for")
+		tier = fs.String("tier", config.TierFree, "rate limit tier")
+	case "list":
+	case "revoke":
+		id = fs.String("id", "", "key ID to revoke")
+	default:
+		return errors.New(apiKeyUsage)
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(configOptions())
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	supabaseDB, err := db.InitSupabase(cfg.SupabaseConnectionString.Value())
+	if err != nil {
+		return fmt.Errorf("failed to initialize Supabase DB: %w", err)
+	}
+	defer supabaseDB.Close()
+
+	if err := db.Migrate(ctx, supabaseDB); err != nil {
+		return fmt.Errorf("failed to apply migrations: %w", err)
+	}
+	service := services.NewAPIKeyService(repositories.NewAPIKeyRepository(supabaseDB), apiKeyTiers(cfg))
+
+	switch args[0] {
+	case "create":
+		plain, key, err := service.IssueAPIKey(ctx, *name, *tier)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("id:   %s\ntier: %s\nkey:  %s\n\nStore the key now; it cannot be shown again.\n", key.Id, key.Tier, plain)
+		return nil
+
+	case "list":
+		keys, err := service.ListAPIKeys(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tPREFIX\tNAME\tTIER\tCREATED\tREVOKED")
+		for _, k := range keys {
+			revoked := "-"
+			if k.RevokedAt != nil {
+				revoked = k.RevokedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", k.Id, k.Prefix, k.Name, k.Tier, k.CreatedAt.Format(time.RFC3339), revoked)
+		}
+		return w.Flush()
+
+	default: // revoke
+		if *id == "" {
+			return errors.New("apikey revoke: -id is required")
+		}
+		if err := service.RevokeAPIKey(ctx, *id); err != nil {
+			return err
+		}
+		fmt.Printf("revoked %s\n", *id)
+		return nil
+	}
+}
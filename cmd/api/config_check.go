@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"stock-talk-service/internal/config"
+	"text/tabwriter"
+)
+
+const configUsage = `usage: api config check [-config <file>] [flags]
+
+Prints the effective configuration, with secrets redacted, and exits
+non-zero if it is invalid.`
+
+// runConfig inspects the configuration without starting the server.
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return errors.New(configUsage)
+	}
+
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	configOptions := config.BindFlags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	settings, err := config.Check(configOptions())
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	for _, s := range settings {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Key, s.Value, s.Source)
+	}
+	if flushErr := w.Flush(); flushErr != nil {
+		return flushErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	fmt.Println("\nconfiguration OK")
+	return nil
+}
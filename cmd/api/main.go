@@ -1,106 +1,322 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
 	"stock-talk-service/internal/config"
 	"stock-talk-service/internal/db"
 	"stock-talk-service/internal/ftp_client"
 	"stock-talk-service/internal/handlers"
+	"stock-talk-service/internal/health"
+	"stock-talk-service/internal/invalidation"
+	"stock-talk-service/internal/lock"
+	"stock-talk-service/internal/logging"
+	"stock-talk-service/internal/metrics"
+	"stock-talk-service/internal/policy"
+	"stock-talk-service/internal/ratelimit"
 	"stock-talk-service/internal/repositories"
+	"stock-talk-service/internal/router"
 	"stock-talk-service/internal/services"
 	"stock-talk-service/internal/tasks"
+	"stock-talk-service/internal/telemetry"
+	"stock-talk-service/internal/validation"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	var err error
+	switch {
+	case len(os.Args) > 1 && os.Args[1] == "apikey":
+		err = runAPIKey(os.Args[2:])
+	case len(os.Args) > 1 && os.Args[1] == "config":
+		err = runConfig(os.Args[2:])
+	default:
+		err = run(os.Args[1:])
+	}
+	if err != nil {
+		slog.Error("fatal", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run wires up and serves the API until SIGINT/SIGTERM. Deferred closes run
+// in reverse order of construction once the server and scheduler have
+// drained, so they also run when startup fails part-way.
+func run(args []string) error {
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+	configOptions := config.BindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Load Configurations
-	cfg, err := config.Load()
+	cfg, err := config.Load(configOptions())
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("invalid configuration: %w", err)
 	}
+	if err := logging.Setup(cfg.LogLevel, cfg.LogFormat); err != nil {
+		return err
+	}
+	for _, w := range cfg.Warnings {
+		slog.Warn(w)
+	}
+	slog.Info("configuration loaded", "config", cfg)
 
-	supabaseDB, err := db.InitSupabase(cfg.SupabaseConnectionString)
+	shutdownTracing, err := telemetry.Setup(ctx, cfg.TracingExporter, cfg.TracingSampleRatio)
 	if err != nil {
-		log.Fatalf("Failed to initialize Supabase DB: %v", err)
+		return fmt.Errorf("failed to set up tracing: %w", err)
 	}
-	defer supabaseDB.Close()
+	// Runs last so spans from the rest of shutdown are flushed.
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		closeWithLog("tracing", func() error { return shutdownTracing(flushCtx) })
+	}()
 
-	// Initialize FTP client for stocks
-	ftpClient, err := ftp_client.NewFTPClient(cfg.NasdaqFTPAddress)
+	supabaseDB, err := db.InitSupabase(cfg.SupabaseConnectionString.Value())
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("failed to initialize Supabase DB: %w", err)
+	}
+	defer closeWithLog("database", supabaseDB.Close)
+
+	if err := db.Migrate(ctx, supabaseDB); err != nil {
+		return fmt.Errorf("failed to apply migrations: %w", err)
 	}
-	defer ftpClient.Close()
+
+	// FTP client for stocks; connects on demand during each sync
+	ftpClient := ftp_client.NewFTPClient(cfg.NasdaqFTPAddress, ftp_client.Options{
+		DialTimeout:  cfg.FTP.DialTimeout,
+		ReadTimeout:  cfg.FTP.ReadTimeout,
+		Retries:      cfg.FTP.Retries,
+		RetryBackoff: cfg.FTP.RetryBackoff,
+		PassiveMode:  cfg.FTP.PassiveMode,
+	})
+
+	// Cross-replica cache invalidation
+	cacheBus := invalidation.NewBus(supabaseDB, cfg.SupabaseConnectionString.Value(), cfg.CachePollInterval)
+	defer closeWithLog("cache invalidation", cacheBus.Close)
 
 	// Set up repositories and services
-	stockRepo := repositories.NewStockRepository(supabaseDB)
-	stockService := services.NewStockService(ftpClient, stockRepo)
+	stockRepo := repositories.NewStockRepository(supabaseDB, cacheBus)
+	historyRepo := repositories.NewHistoryRepository(supabaseDB)
+	syncRunRepo := repositories.NewSyncRunRepository(supabaseDB)
+	syncPolicy := policy.New(cfg.SyncPolicy.Actions, cfg.SyncPolicy.MissingRuns)
+	stockService := services.NewStockService(ftpClient, stockRepo, historyRepo, syncRunRepo, cfg.SyncGuards, syncPolicy)
 
-	cryptoRepo := repositories.NewCryptoRepository(supabaseDB)
-	cryptoService := services.NewCryptoService(cryptoRepo, cfg)
+	cryptoRepo := repositories.NewCryptoRepository(supabaseDB, cacheBus)
+	cryptoService := services.NewCryptoService(cryptoRepo, historyRepo, syncRunRepo, syncPolicy, cfg)
 
 	watchlistRepo := repositories.NewWatchlistRepository(supabaseDB)
 	watchlistService := services.NewWatchlistService(watchlistRepo)
 
-	// Initial data fetch if DBs are empty
-	if err := stockRepo.LoadStockCache(); err != nil {
-		log.Fatalf("Failed to load stock cache: %v", err)
+	if err := backfillUIDs(ctx, stockRepo, watchlistRepo); err != nil {
+		return err
+	}
+
+	apiKeyService := services.NewAPIKeyService(repositories.NewAPIKeyRepository(supabaseDB), apiKeyTiers(cfg))
+
+	// Background job scheduler
+	scheduler := tasks.NewScheduler(cfg.Jobs, lock.New(supabaseDB))
+	for _, job := range []tasks.Job{
+		tasks.StockSyncJob(stockService, ftpClient),
+		tasks.CryptoSyncJob(cryptoService),
+		tasks.ValidationCacheRefreshJob(cryptoService),
+		tasks.CleanupJob(stockService, cryptoService, cfg.ReviewRetention),
+	} {
+		if err := scheduler.Register(job); err != nil {
+			return fmt.Errorf("failed to schedule job: %w", err)
+		}
+	}
+
+	// Readiness reports not ready until the caches below are warm
+	checker := health.NewChecker(cfg.HealthCheckTimeout, scheduler.LastSuccess, config.JobStockSync, config.JobCryptoSync)
+	checker.Add(health.DBCheck(supabaseDB))
+	checker.AddPeriodic(health.TCPCheck("ftp", cfg.NasdaqFTPAddress, false), cfg.HealthUpstreamInterval)
+	checker.AddPeriodic(health.HTTPCheck("coingecko", cfg.CoingeckoBaseUrl+"/ping", cfg.HealthCheckTimeout, false), cfg.HealthUpstreamInterval)
+	checker.Add(health.CacheCheck("stock_cache", stockRepo.CacheSize))
+	checker.Add(health.CacheCheck("crypto_cache", cryptoRepo.CacheSize))
+	checker.Start(ctx)
+
+	metrics.RegisterCacheSize("stock", stockService.CacheSize)
+	metrics.RegisterCacheSize("crypto", cryptoService.CacheSize)
+	metrics.RegisterPendingReviews(map[string]func(ctx context.Context) (int, error){
+		"stock":  stockService.CountPendingReviews,
+		"crypto": cryptoService.CountPendingReviews,
+	})
+
+	// Gin HTTP server setup. Debug mode prints unstructured route tables, so
+	// only enable it alongside debug logging.
+	if !strings.EqualFold(cfg.LogLevel, "debug") {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	if err := validation.RegisterBindings(); err != nil {
+		return err
+	}
+	r, err := router.New(router.Handlers{
+		Health:    handlers.NewHealthGinHandler(checker),
+		Crypto:    handlers.NewCryptoGinHandler(cryptoService),
+		Stock:     handlers.NewStockGinHandler(stockService),
+		Watchlist: handlers.NewWatchlistGinHandler(watchlistService),
+	}, router.Options{
+		Server:         cfg.Server,
+		APIKeys:        apiKeyService,
+		APIKeyRequired: cfg.APIKeyRequired,
+		RateLimiters:   rateLimiters(cfg),
+	})
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:              cfg.Server.Addr(),
+		Handler:           r,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("server running", "addr", srv.Addr, "tls", cfg.Server.TLSEnabled())
+		var err error
+		if cfg.Server.TLSEnabled() {
+			err = srv.ListenAndServeTLS(cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
+		}
+		close(serveErr)
+	}()
+
+	// Serve liveness while the caches load; readiness flips once they're warm
+	if err := warmCaches(ctx, stockService, cryptoService); err != nil {
+		shutdown(srv, scheduler, cfg.ShutdownTimeout)
+		return err
+	}
+
+	cacheBus.Subscribe(invalidation.CacheStock, stockRepo.LoadStockCache)
+	cacheBus.Subscribe(invalidation.CacheCrypto, cryptoRepo.LoadCryptoCache)
+	if err := cacheBus.Start(ctx); err != nil {
+		shutdown(srv, scheduler, cfg.ShutdownTimeout)
+		return fmt.Errorf("failed to start cache invalidation: %w", err)
+	}
+	scheduler.Start()
+
+	select {
+	case err := <-serveErr:
+		stopScheduler(scheduler, cfg.ShutdownTimeout)
+		return fmt.Errorf("failed to start server: %w", err)
+	case <-ctx.Done():
+	}
+	stop()
+	slog.Info("shutdown signal received, draining")
+	shutdown(srv, scheduler, cfg.ShutdownTimeout)
+
+	slog.Info("shutdown complete")
+	return nil
+}
+
+// rateLimiters builds one limiter per configured tier
+func rateLimiters(cfg *config.Config) map[string]*ratelimit.Limiter {
+	limiters := make(map[string]*ratelimit.Limiter, len(cfg.RateLimits))
+	for tier, limit := range cfg.RateLimits {
+		limiters[tier] = ratelimit.New(limit.Requests, limit.Period, cfg.RateLimitReplicas)
 	}
-	if stocks := stockRepo.GetAllStocks(); len(stocks) == 0 {
-		log.Println("Fetching stocks at startup...")
-		if err := stockService.InitializeStocks(); err != nil {
-			log.Fatalf("Failed to initialize stock data: %v", err)
+	return limiters
+}
+
+// apiKeyTiers lists the tiers keys may be issued with. Anonymous is reserved
+// for requests without a key.
+func apiKeyTiers(cfg *config.Config) []string {
+	var tiers []string
+	for tier := range cfg.RateLimits {
+		if tier != config.TierAnonymous {
+			tiers = append(tiers, tier)
 		}
 	}
-	if err := cryptoRepo.LoadCryptoCache(); err != nil {
-		log.Fatalf("Failed to load crypto cache: %v", err)
+	return tiers
+}
+
+// backfillUIDs assigns stable uids to stocks that lack one, then keys
+// watchlist items written before uids existed by them
+func backfillUIDs(ctx context.Context, stockRepo *repositories.StockRepository, watchlistRepo *repositories.WatchlistRepository) error {
+	assigned, err := stockRepo.AssignMissingUIDs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to assign stock uids: %w", err)
+	}
+	filled, unmatched, err := watchlistRepo.BackfillItemUIDs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to backfill watchlist item uids: %w", err)
 	}
-	if crypto := cryptoRepo.GetAllCrypto(); len(crypto) == 0 {
-		log.Println("Fetching Crypto at startup...")
-		if err := cryptoService.InitializeCrypto(); err != nil {
-			log.Fatalf("Failed to initialize crypto data: %v", err)
+	if assigned > 0 || filled > 0 {
+		slog.InfoContext(ctx, "stable ids backfilled", "stocks", assigned, "watchlist_items", filled)
+	}
+	if unmatched > 0 {
+		slog.WarnContext(ctx, "watchlist items reference rows that no longer exist", "count", unmatched)
+	}
+	return nil
+}
+
+// warmCaches loads both caches, fetching the catalogs if the DB is empty
+func warmCaches(ctx context.Context, stockService *services.StockService, cryptoService *services.CryptoService) error {
+	if err := stockService.ReloadStockCache(ctx); err != nil {
+		return fmt.Errorf("failed to load stock cache: %w", err)
+	}
+	if stocks := stockService.GetAllStocks(true); len(stocks) == 0 {
+		slog.InfoContext(ctx, "fetching stocks at startup")
+		if err := stockService.InitializeStocks(ctx); err != nil {
+			return fmt.Errorf("failed to initialize stock data: %w", err)
+		}
+	}
+	if err := cryptoService.ReloadCryptoCache(ctx); err != nil {
+		return fmt.Errorf("failed to load crypto cache: %w", err)
+	}
+	if crypto := cryptoService.GetAllCrypto(true); len(crypto) == 0 {
+		slog.InfoContext(ctx, "fetching crypto at startup")
+		if err := cryptoService.InitializeCrypto(ctx); err != nil {
+			return fmt.Errorf("failed to initialize crypto data: %w", err)
 		}
 	}
+	return nil
+}
+
+// shutdown stops taking requests first, then waits for in-flight jobs. The
+// deferred closes in run release the cache bus and DB afterwards.
+func shutdown(srv *http.Server, scheduler *tasks.Scheduler, timeout time.Duration) {
+	drainCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		slog.Error("HTTP server shutdown", "error", err)
+	}
+	stopScheduler(scheduler, timeout)
+}
+
+func stopScheduler(scheduler *tasks.Scheduler, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := scheduler.Stop(ctx); err != nil {
+		slog.Error("scheduler shutdown", "error", err)
+	}
+}
 
-	// Daily update scheduler
-	tasks.ScheduleDailyUpdates(stockService, cryptoService)
-
-	// Gin HTTP server setup
-	r := gin.Default()
-	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:5173"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
-
-	// Handlers
-	cryptoHandler := handlers.NewCryptoGinHandler(cryptoService)
-	r.GET("/crypto", cryptoHandler.GetAllCrypto)
-	r.GET("/crypto/:id", cryptoHandler.GetCryptoByID)
-	r.POST("/crypto/price", cryptoHandler.GetCryptoPrice)
-	r.POST("/crypto/history", cryptoHandler.GetCryptoHistory)
-	r.POST("/crypto/history-ohlc", cryptoHandler.GetCryptoHistoryOHLC)
-
-	stockHandler := handlers.NewStockGinHandler(stockService)
-	r.GET("/stocks", stockHandler.GetAllStocks)
-	r.GET("/stocks/:ticker", stockHandler.GetStockByTicker)
-
-	watchlistHandler := handlers.NewWatchlistGinHandler(watchlistService)
-	r.GET("/watchlists", watchlistHandler.GetAllWatchlists)
-	r.GET("/watchlists/:id", watchlistHandler.GetWatchlistByID)
-	r.POST("/create-watchlist", watchlistHandler.CreateWatchlist)
-	r.PUT("/watchlists/:id", watchlistHandler.UpdateWatchlist)
-	r.DELETE("/watchlists/:id", watchlistHandler.DeleteWatchlist)
-
-	port := ":8080"
-	log.Printf("Server running on port %s", port)
-	if err := r.Run(port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+func closeWithLog(name string, closeFn func() error) {
+	if err := closeFn(); err != nil {
+		slog.Error("closing component", "component", name, "error", err)
 	}
 }
@@ -0,0 +1,132 @@
+package apperrors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Code is the stable, machine-readable error identifier sent to clients.
+type Code string
+
+const (
+	CodeNotFound     Code = "not_found"
+	CodeValidation   Code = "validation_failed"
+	CodeUnauthorized Code = "unauthorized"
+	CodeConflict     Code = "conflict"
+	CodeTooLarge     Code = "payload_too_large"
+	CodeUpstream     Code = "upstream_unavailable"
+	CodeRateLimited  Code = "rate_limited"
+	CodeInternal     Code = "internal_error"
+)
+
+// Error is a domain error that the HTTP layer can render. Message and Details
+// are shown to clients; Err is the underlying cause and is only logged.
+type Error struct {
+	Code       Code
+	Message    string
+	Details    any
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Status maps the error code to an HTTP status.
+func (e *Error) Status() int {
+	switch e.Code {
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeValidation:
+		return http.StatusBadRequest
+	case CodeUnauthorized:
+		return http.StatusUnauthorized
+	case CodeConflict:
+		return http.StatusConflict
+	case CodeTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case CodeUpstream:
+		return http.StatusBadGateway
+	case CodeRateLimited:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// NotFound reports a missing resource, e.g. NotFound("watchlist", id).
+func NotFound(resource string, id any) *Error {
+	return &Error{
+		Code:    CodeNotFound,
+		Message: fmt.Sprintf("%s %v not found", resource, id),
+	}
+}
+
+// Validation reports bad client input. details is rendered as-is, typically
+// a map of field to problem.
+func Validation(message string, details any) *Error {
+	return &Error{Code: CodeValidation, Message: message, Details: details}
+}
+
+// Unauthorized reports missing or rejected credentials.
+func Unauthorized(message string) *Error {
+	return &Error{Code: CodeUnauthorized, Message: message}
+}
+
+// Conflict reports a write that clashes with existing state.
+func Conflict(message string, err error) *Error {
+	return &Error{Code: CodeConflict, Message: message, Err: err}
+}
+
+// TooLarge reports a request body over the configured limit.
+func TooLarge(limit int64) *Error {
+	return &Error{
+		Code:    CodeTooLarge,
+		Message: fmt.Sprintf("request body exceeds %d bytes", limit),
+	}
+}
+
+// Upstream reports a failed call to an external dependency. The cause is
+// kept for logs; clients only see which upstream failed.
+func Upstream(upstream string, err error) *Error {
+	return &Error{
+		Code:    CodeUpstream,
+		Message: upstream + " is unavailable",
+		Details: map[string]string{"upstream": upstream},
+		Err:     err,
+	}
+}
+
+// RateLimited reports that the caller, or we towards an upstream, has
+// exceeded a quota. retryAfter may be zero when unknown.
+func RateLimited(message string, retryAfter time.Duration) *Error {
+	return &Error{Code: CodeRateLimited, Message: message, RetryAfter: retryAfter}
+}
+
+// Internal wraps an unexpected error. Its cause is never shown to clients.
+func Internal(err error) *Error {
+	return &Error{Code: CodeInternal, Message: "internal server error", Err: err}
+}
+
+// From returns err as a domain error, treating anything else as internal.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return Internal(err)
+}
+
+// Is reports whether err is a domain error with the given code.
+func Is(err error, code Code) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
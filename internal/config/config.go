@@ -1,47 +1,416 @@
 package config
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/joho/godotenv"
+	"github.com/robfig/cron/v3"
 )
 
 type Config struct {
-    NasdaqFTPAddress string
-    StockDB string
-	CoingeckoBaseUrl string
-	CryptoDB string
-	SupabaseConnectionString string
-}
-
-func Load() (*Config, error) {
-    // Load .env file if present
-    err := godotenv.Load("../../.env")
-    if err != nil {
-        // .env file not found or can't be read
-        fmt.Println("Warning: .env file not loaded:", err)
-        // Not necessarily fatal; env vars could come from elsewhere
-    }
-
-	// NASDAQ FTP
-    nasdaqFTPAddress := os.Getenv("NASDAQ_FTP_ADDRESS")
-
-	// SQLITE DB
-	stockDB := os.Getenv("STOCK_DB")
-	cryptoDB := os.Getenv("CRYPTO_DB")
-
-	// Coingecko API
-	coingeckoBaseUrl := os.Getenv("COINGECKO_BASE_URL")
-
-	// Supabase connection string
-	supabaseConnectionString := os.Getenv("SUPABASE_CONNECTION_STRING")
-
-    return &Config{
-        NasdaqFTPAddress: nasdaqFTPAddress,
-		StockDB: stockDB,
-		CoingeckoBaseUrl: coingeckoBaseUrl,
-		CryptoDB: cryptoDB,
-		SupabaseConnectionString: supabaseConnectionString,
-    }, nil
-}
\ No newline at end of file
+	NasdaqFTPAddress         string
+	FTP                      FTPConfig
+	StockDB                  string
+	CoingeckoBaseUrl         string
+	CoinMappingFile          string
+	CryptoDB                 string
+	SupabaseConnectionString Secret
+	Jobs                     map[string]JobConfig
+	ReviewRetention          time.Duration
+	SyncGuards               SyncGuards
+	SyncPolicy               SyncPolicy
+	CachePollInterval        time.Duration
+	ShutdownTimeout          time.Duration
+	HealthCheckTimeout       time.Duration
+	HealthUpstreamInterval   time.Duration
+	LogLevel                 string
+	LogFormat                string
+	TracingExporter          string
+	TracingSampleRatio       float64
+	APIKeyRequired           bool
+	RateLimits               map[string]RateLimit
+	RateLimitReplicas        int
+	Server                   ServerConfig
+
+	// Warnings are collected during Load, before logging is configured,
+	// and logged by the caller once it is.
+	Warnings []string
+
+	settings []Setting
+}
+
+// JobConfig holds the schedule settings for a single background job.
+type JobConfig struct {
+	Spec     string
+	Timezone string
+	Timeout  time.Duration
+	Enabled  bool
+}
+
+// FTPConfig tunes the NASDAQ FTP client. Each retrieval dials its own
+// connection, so these apply per file.
+type FTPConfig struct {
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	Retries      int
+	RetryBackoff time.Duration
+	// PassiveMode is "auto" (EPSV, falling back to PASV) or "pasv".
+	PassiveMode string
+}
+
+// SyncGuards abort a catalog sync whose diff against the stored catalog is
+// implausibly large, as a percentage of the stored entries. They catch
+// truncated or partial upstream files before they reach the review queue.
+type SyncGuards struct {
+	MaxMissingPercent float64
+	MaxAddedPercent   float64
+	MaxChangedPercent float64
+}
+
+// SyncPolicy chooses per review reason whether a sync applies the change to
+// the catalog, queues it for review or ignores it.
+type SyncPolicy struct {
+	// Actions maps a review reason to "apply", "review" or "ignore".
+	Actions map[string]string
+	// MissingRuns is how many consecutive syncs an item must be missing
+	// before a missing reason set to apply deactivates it.
+	MissingRuns int
+}
+
+// defaultSyncPolicy adds new listings and deactivates delistings, but leaves
+// renames to a reviewer since they're the likeliest to be feed noise.
+var defaultSyncPolicy = map[string]string{
+	"ticker_new":          "apply",
+	"ticker_missing":      "apply",
+	"name_changed":        "review",
+	"uid_new":             "apply",
+	"uid_missing":         "apply",
+	"ticker_changed":      "review",
+	"name_ticker_changed": "review",
+}
+
+// RateLimit is a token bucket holding Requests tokens that refill evenly
+// over Period.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// Rate limit tiers. Anonymous applies per client IP; the others are
+// assigned to API keys.
+const (
+	TierAnonymous = "anonymous"
+	TierFree      = "free"
+	TierPro       = "pro"
+)
+
+var defaultRateLimits = map[string]RateLimit{
+	TierAnonymous: {Requests: 60, Period: time.Minute},
+	TierFree:      {Requests: 300, Period: time.Minute},
+	TierPro:       {Requests: 3000, Period: time.Minute},
+}
+
+// Job names used as keys in Config.Jobs and as the JOB_<NAME>_* env prefix.
+const (
+	JobStockSync              = "stock_sync"
+	JobCryptoSync             = "crypto_sync"
+	JobValidationCacheRefresh = "validation_cache_refresh"
+	JobCleanup                = "cleanup"
+)
+
+// defaultJobs mirrors the original single midnight run for the syncs.
+var defaultJobs = map[string]JobConfig{
+	JobStockSync:              {Spec: "0 0 * * *", Timezone: "UTC", Timeout: 30 * time.Minute, Enabled: true},
+	JobCryptoSync:             {Spec: "0 0 * * *", Timezone: "UTC", Timeout: 30 * time.Minute, Enabled: true},
+	JobValidationCacheRefresh: {Spec: "0 */6 * * *", Timezone: "UTC", Timeout: 5 * time.Minute, Enabled: true},
+	JobCleanup:                {Spec: "30 1 * * *", Timezone: "UTC", Timeout: 10 * time.Minute, Enabled: true},
+}
+
+// Load resolves and validates the configuration. The error lists every
+// problem found, one per line.
+func Load(opts Options) (*Config, error) {
+	cfg, _, err := load(opts)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Check resolves the configuration like Load but also returns the effective
+// settings, with secrets redacted, when validation fails.
+func Check(opts Options) ([]Setting, error) {
+	_, settings, err := load(opts)
+	return settings, err
+}
+
+func load(opts Options) (*Config, []Setting, error) {
+	e, err := newEnv(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg := &Config{
+		// NASDAQ FTP
+		NasdaqFTPAddress: e.get("NASDAQ_FTP_ADDRESS"),
+		FTP: FTPConfig{
+			DialTimeout:  e.getDuration("FTP_DIAL_TIMEOUT", 10*time.Second),
+			ReadTimeout:  e.getDuration("FTP_READ_TIMEOUT", 60*time.Second),
+			Retries:      e.getInt("FTP_RETRIES", 3),
+			RetryBackoff: e.getDuration("FTP_RETRY_BACKOFF", 2*time.Second),
+			PassiveMode:  strings.ToLower(e.getString("FTP_PASSIVE_MODE", "auto")),
+		},
+
+		// SQLITE DB
+		StockDB:  e.get("STOCK_DB"),
+		CryptoDB: e.get("CRYPTO_DB"),
+
+		// Coingecko API
+		CoingeckoBaseUrl: strings.TrimRight(e.get("COINGECKO_BASE_URL"), "/"),
+		CoinMappingFile:  e.getString("COIN_MAPPING_FILE", "data/coin_mapping.json"),
+
+		// Supabase connection string
+		SupabaseConnectionString: Secret(e.get("SUPABASE_CONNECTION_STRING")),
+
+		// Scheduled jobs and cache upkeep
+		Jobs:               loadJobs(e),
+		ReviewRetention:    e.getDuration("REVIEW_RETENTION", 30*24*time.Hour),
+		CachePollInterval:  e.getDuration("CACHE_POLL_INTERVAL", 30*time.Second),
+		ShutdownTimeout:    e.getDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		HealthCheckTimeout: e.getDuration("HEALTH_CHECK_TIMEOUT", 3*time.Second),
+
+		// Upstreams are probed in the background, not on every /readyz
+		HealthUpstreamInterval: e.getDuration("HEALTH_UPSTREAM_INTERVAL", 30*time.Second),
+
+		// Catalog sync safety guards
+		SyncGuards: SyncGuards{
+			MaxMissingPercent: e.getFloat("SYNC_MAX_MISSING_PERCENT", 5),
+			MaxAddedPercent:   e.getFloat("SYNC_MAX_ADDED_PERCENT", 10),
+			MaxChangedPercent: e.getFloat("SYNC_MAX_CHANGED_PERCENT", 10),
+		},
+
+		// Catalog sync policy
+		SyncPolicy: SyncPolicy{
+			Actions:     loadSyncPolicy(e),
+			MissingRuns: e.getInt("SYNC_MISSING_RUNS", 3),
+		},
+
+		// Logging
+		LogLevel:  e.getString("LOG_LEVEL", "info"),
+		LogFormat: e.getString("LOG_FORMAT", "json"),
+
+		// Tracing
+		TracingExporter:    e.getString("TRACING_EXPORTER", "none"),
+		TracingSampleRatio: e.getFloat("TRACING_SAMPLE_RATIO", 1.0),
+
+		// API keys and rate limiting
+		APIKeyRequired:    e.getBool("API_KEY_REQUIRED", false),
+		RateLimits:        loadRateLimits(e),
+		RateLimitReplicas: e.getInt("RATE_LIMIT_REPLICAS", 1),
+
+		// HTTP server
+		Server: loadServer(e),
+	}
+	if cfg.Server.allowsAnyOrigin() {
+		cfg.Warnings = append(cfg.Warnings, "CORS_ALLOWED_ORIGINS is *: any origin may call the API, without credentials")
+	}
+
+	cfg.settings = e.sortedSettings()
+	if err := errors.Join(append(e.errs, cfg.validate()...)...); err != nil {
+		return nil, cfg.settings, err
+	}
+	return cfg, cfg.settings, nil
+}
+
+// validate checks required values and cross-field rules.
+func (c *Config) validate() []error {
+	var errs []error
+	required := func(key, value string) bool {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s is required", key))
+			return false
+		}
+		return true
+	}
+
+	if required("SUPABASE_CONNECTION_STRING", c.SupabaseConnectionString.Value()) {
+		if err := validateConnectionString(c.SupabaseConnectionString.Value()); err != nil {
+			errs = append(errs, fmt.Errorf("SUPABASE_CONNECTION_STRING: %w", err))
+		}
+	}
+	if required("NASDAQ_FTP_ADDRESS", c.NasdaqFTPAddress) {
+		if _, port, err := net.SplitHostPort(c.NasdaqFTPAddress); err != nil || port == "" {
+			errs = append(errs, fmt.Errorf("NASDAQ_FTP_ADDRESS: %q must be host:port", c.NasdaqFTPAddress))
+		}
+	}
+	if required("COINGECKO_BASE_URL", c.CoingeckoBaseUrl) {
+		if u, err := url.Parse(c.CoingeckoBaseUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("COINGECKO_BASE_URL: %q must be an http(s) URL", c.CoingeckoBaseUrl))
+		}
+	}
+
+	for key, d := range map[string]time.Duration{
+		"REVIEW_RETENTION":         c.ReviewRetention,
+		"CACHE_POLL_INTERVAL":      c.CachePollInterval,
+		"SHUTDOWN_TIMEOUT":         c.ShutdownTimeout,
+		"HEALTH_CHECK_TIMEOUT":     c.HealthCheckTimeout,
+		"HEALTH_UPSTREAM_INTERVAL": c.HealthUpstreamInterval,
+		"FTP_DIAL_TIMEOUT":         c.FTP.DialTimeout,
+		"FTP_READ_TIMEOUT":         c.FTP.ReadTimeout,
+		"FTP_RETRY_BACKOFF":        c.FTP.RetryBackoff,
+	} {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got %s", key, d))
+		}
+	}
+
+	for key, pct := range map[string]float64{
+		"SYNC_MAX_MISSING_PERCENT": c.SyncGuards.MaxMissingPercent,
+		"SYNC_MAX_ADDED_PERCENT":   c.SyncGuards.MaxAddedPercent,
+		"SYNC_MAX_CHANGED_PERCENT": c.SyncGuards.MaxChangedPercent,
+	} {
+		if pct < 0 || pct > 100 {
+			errs = append(errs, fmt.Errorf("%s must be between 0 and 100, got %g", key, pct))
+		}
+	}
+
+	for reason, action := range c.SyncPolicy.Actions {
+		if action != "apply" && action != "review" && action != "ignore" {
+			errs = append(errs, fmt.Errorf("SYNC_POLICY_%s: %q must be apply, review or ignore", strings.ToUpper(reason), action))
+		}
+	}
+	if c.SyncPolicy.MissingRuns < 1 {
+		errs = append(errs, fmt.Errorf("SYNC_MISSING_RUNS must be at least 1, got %d", c.SyncPolicy.MissingRuns))
+	}
+
+	if c.RateLimitReplicas < 1 {
+		errs = append(errs, fmt.Errorf("RATE_LIMIT_REPLICAS must be at least 1, got %d", c.RateLimitReplicas))
+	}
+
+	if c.FTP.Retries < 0 {
+		errs = append(errs, fmt.Errorf("FTP_RETRIES must not be negative, got %d", c.FTP.Retries))
+	}
+	if m := c.FTP.PassiveMode; m != "auto" && m != "pasv" {
+		errs = append(errs, fmt.Errorf("FTP_PASSIVE_MODE: %q must be auto or pasv", m))
+	}
+
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: %q must be debug, info, warn or error", c.LogLevel))
+	}
+	if f := strings.ToLower(c.LogFormat); f != "json" && f != "text" {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT: %q must be json or text", c.LogFormat))
+	}
+	if x := strings.ToLower(c.TracingExporter); x != "none" && x != "otlp" {
+		errs = append(errs, fmt.Errorf("TRACING_EXPORTER: %q must be none or otlp", c.TracingExporter))
+	}
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("TRACING_SAMPLE_RATIO must be between 0 and 1, got %g", c.TracingSampleRatio))
+	}
+
+	for name, job := range c.Jobs {
+		prefix := "JOB_" + strings.ToUpper(name) + "_"
+		if _, err := cron.ParseStandard(job.Spec); err != nil {
+			errs = append(errs, fmt.Errorf("%sCRON: %q: %w", prefix, job.Spec, err))
+		}
+		if _, err := time.LoadLocation(job.Timezone); err != nil {
+			errs = append(errs, fmt.Errorf("%sTZ: %q: %w", prefix, job.Timezone, err))
+		}
+		if job.Timeout <= 0 {
+			errs = append(errs, fmt.Errorf("%sTIMEOUT must be positive, got %s", prefix, job.Timeout))
+		}
+	}
+
+	if err := c.Server.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// validateConnectionString accepts postgres:// URLs and key=value DSNs.
+func validateConnectionString(conn string) error {
+	if !strings.Contains(conn, "://") {
+		if !strings.Contains(conn, "=") {
+			return errors.New("must be a postgres:// URL or a key=value DSN")
+		}
+		return nil
+	}
+	u, err := url.Parse(conn)
+	if err != nil {
+		// url.Error repeats the input, which contains the password
+		return errors.New("not a valid URL")
+	}
+	if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return errors.New("missing host")
+	}
+	return nil
+}
+
+// LogValue renders the effective settings with secrets redacted, for
+// slog.Info("...", "config", cfg).
+func (c *Config) LogValue() slog.Value {
+	attrs := make([]slog.Attr, 0, len(c.settings))
+	for _, s := range c.settings {
+		attrs = append(attrs, slog.String(s.Key, s.Value))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// loadJobs applies JOB_<NAME>_CRON, _TZ, _TIMEOUT and _ENABLED overrides on
+// top of defaultJobs.
+func loadJobs(e *env) map[string]JobConfig {
+	jobs := make(map[string]JobConfig, len(defaultJobs))
+	for name, def := range defaultJobs {
+		prefix := "JOB_" + strings.ToUpper(name) + "_"
+		jobs[name] = JobConfig{
+			Spec:     e.getString(prefix+"CRON", def.Spec),
+			Timezone: e.getString(prefix+"TZ", def.Timezone),
+			Timeout:  e.getDuration(prefix+"TIMEOUT", def.Timeout),
+			Enabled:  e.getBool(prefix+"ENABLED", def.Enabled),
+		}
+	}
+	return jobs
+}
+
+// loadSyncPolicy applies SYNC_POLICY_<REASON> overrides on top of
+// defaultSyncPolicy.
+func loadSyncPolicy(e *env) map[string]string {
+	actions := make(map[string]string, len(defaultSyncPolicy))
+	for reason, def := range defaultSyncPolicy {
+		actions[reason] = strings.ToLower(e.getString("SYNC_POLICY_"+strings.ToUpper(reason), def))
+	}
+	return actions
+}
+
+// loadRateLimits applies RATE_LIMIT_<TIER> overrides, written as
+// "<requests>/<period>" (e.g. "120/1m"), on top of defaultRateLimits.
+func loadRateLimits(e *env) map[string]RateLimit {
+	limits := make(map[string]RateLimit, len(defaultRateLimits))
+	for tier, def := range defaultRateLimits {
+		key := "RATE_LIMIT_" + strings.ToUpper(tier)
+		v := e.getString(key, fmt.Sprintf("%d/%s", def.Requests, def.Period))
+
+		limits[tier] = def
+		requests, period, ok := strings.Cut(v, "/")
+		n, err := strconv.Atoi(requests)
+		if !ok || err != nil || n <= 0 {
+			e.fail(fmt.Errorf("%s: %q must be <requests>/<period>, e.g. 120/1m", key, v))
+			continue
+		}
+		d, err := time.ParseDuration(period)
+		if err != nil || d <= 0 {
+			e.fail(fmt.Errorf("%s: %q must be <requests>/<period>, e.g. 120/1m", key, v))
+			continue
+		}
+		limits[tier] = RateLimit{Requests: n, Period: d}
+	}
+	return limits
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Options selects where Load reads settings from. Precedence, highest
+// first: Overrides, the process environment, File, built-in defaults.
+type Options struct {
+	// File is a dotenv-format file. When empty, .env in the working
+	// directory is used if it exists; when set, the file must exist.
+	File string
+	// Overrides are env-style keys, typically set from command-line flags.
+	Overrides map[string]string
+}
+
+// Setting sources reported by Check and in logs.
+const (
+	SourceFlag    = "flag"
+	SourceEnv     = "env"
+	SourceFile    = "file"
+	SourceDefault = "default"
+)
+
+// Setting is one effective configuration value. Secret values are redacted.
+type Setting struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// Secret holds a sensitive string. It prints as [REDACTED] through fmt,
+// slog and encoding/json; use Value to read it.
+type Secret string
+
+const redacted = "[REDACTED]"
+
+func (s Secret) Value() string                { return string(s) }
+func (s Secret) String() string               { return redacted }
+func (s Secret) GoString() string             { return redacted }
+func (s Secret) LogValue() slog.Value         { return slog.StringValue(redacted) }
+func (s Secret) MarshalText() ([]byte, error) { return []byte(redacted), nil }
+
+// secretKeys are never shown in Check output or logs.
+var secretKeys = map[string]bool{
+	"SUPABASE_CONNECTION_STRING": true,
+}
+
+// env resolves settings from the overrides, the process environment and
+// the config file, recording each value's source and any parse errors so
+// Load can report every problem at once.
+type env struct {
+	overrides map[string]string
+	fileKeys  map[string]bool
+	settings  map[string]Setting
+	errs      []error
+}
+
+// newEnv loads the config file into the process environment, without
+// replacing variables that are already set, so libraries reading their own
+// env vars (e.g. OTEL_*) see the file too.
+func newEnv(opts Options) (*env, error) {
+	e := &env{
+		overrides: opts.Overrides,
+		fileKeys:  make(map[string]bool),
+		settings:  make(map[string]Setting),
+	}
+
+	path := opts.File
+	if path == "" {
+		path = ".env"
+	}
+	values, err := godotenv.Read(path)
+	switch {
+	case err == nil:
+	case opts.File == "" && errors.Is(err, fs.ErrNotExist):
+		return e, nil
+	default:
+		return nil, fmt.Errorf("loading config file %s: %w", path, err)
+	}
+
+	for k, v := range values {
+		if _, set := os.LookupEnv(k); set {
+			continue
+		}
+		if err := os.Setenv(k, v); err != nil {
+			return nil, err
+		}
+		e.fileKeys[k] = true
+	}
+	return e, nil
+}
+
+// lookup returns the raw value and where it came from, or "" with
+// SourceDefault when unset.
+func (e *env) lookup(key string) (string, string) {
+	if v, ok := e.overrides[key]; ok {
+		return v, SourceFlag
+	}
+	if v := os.Getenv(key); v != "" {
+		if e.fileKeys[key] {
+			return v, SourceFile
+		}
+		return v, SourceEnv
+	}
+	return "", SourceDefault
+}
+
+func (e *env) record(key, value, source string) {
+	if secretKeys[key] && value != "" {
+		value = redacted
+	}
+	e.settings[key] = Setting{Key: key, Value: value, Source: source}
+}
+
+func (e *env) fail(err error) {
+	e.errs = append(e.errs, err)
+}
+
+func (e *env) get(key string) string {
+	v, src := e.lookup(key)
+	e.record(key, v, src)
+	return v
+}
+
+func (e *env) getString(key, def string) string {
+	v, src := e.lookup(key)
+	if v == "" {
+		v = def
+	}
+	e.record(key, v, src)
+	return v
+}
+
+func (e *env) getInt(key string, def int) int {
+	v := e.getString(key, strconv.Itoa(def))
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		e.fail(fmt.Errorf("%s: %q is not an integer", key, v))
+		return def
+	}
+	return n
+}
+
+func (e *env) getInt64(key string, def int64) int64 {
+	v := e.getString(key, strconv.FormatInt(def, 10))
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		e.fail(fmt.Errorf("%s: %q is not an integer", key, v))
+		return def
+	}
+	return n
+}
+
+func (e *env) getFloat(key string, def float64) float64 {
+	v := e.getString(key, strconv.FormatFloat(def, 'g', -1, 64))
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		e.fail(fmt.Errorf("%s: %q is not a number", key, v))
+		return def
+	}
+	return f
+}
+
+func (e *env) getDuration(key string, def time.Duration) time.Duration {
+	v := e.getString(key, def.String())
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		e.fail(fmt.Errorf("%s: %q is not a duration (e.g. 30s, 5m, 2h)", key, v))
+		return def
+	}
+	return d
+}
+
+func (e *env) getBool(key string, def bool) bool {
+	v := e.getString(key, strconv.FormatBool(def))
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		e.fail(fmt.Errorf("%s: %q is not a boolean", key, v))
+		return def
+	}
+	return b
+}
+
+// sortedSettings returns the recorded settings ordered by key.
+func (e *env) sortedSettings() []Setting {
+	out := make([]Setting, 0, len(e.settings))
+	for _, s := range e.settings {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
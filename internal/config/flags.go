@@ -0,0 +1,44 @@
+package config
+
+import (
+	"flag"
+	"os"
+)
+
+// flagEnv maps command-line flags to the env keys they override.
+var flagEnv = map[string]string{
+	"port":            "PORT",
+	"cors-origins":    "CORS_ALLOWED_ORIGINS",
+	"read-timeout":    "SERVER_READ_TIMEOUT",
+	"write-timeout":   "SERVER_WRITE_TIMEOUT",
+	"idle-timeout":    "SERVER_IDLE_TIMEOUT",
+	"max-body-bytes":  "MAX_BODY_BYTES",
+	"tls-cert":        "TLS_CERT_FILE",
+	"tls-key":         "TLS_KEY_FILE",
+	"trusted-proxies": "TRUSTED_PROXIES",
+	"log-level":       "LOG_LEVEL",
+}
+
+// BindFlags registers -config and the server setting flags on fs. Call the
+// returned function after fs.Parse to get Options for Load; only flags that
+// were actually given override other sources.
+func BindFlags(fs *flag.FlagSet) func() Options {
+	file := fs.String("config", "", "path to a dotenv-format config file (env: CONFIG_FILE)")
+	values := make(map[string]*string, len(flagEnv))
+	for name, key := range flagEnv {
+		values[name] = fs.String(name, "", "overrides "+key)
+	}
+
+	return func() Options {
+		opts := Options{File: *file, Overrides: make(map[string]string)}
+		if opts.File == "" {
+			opts.File = os.Getenv("CONFIG_FILE")
+		}
+		fs.Visit(func(f *flag.Flag) {
+			if key, ok := flagEnv[f.Name]; ok {
+				opts.Overrides[key] = *values[f.Name]
+			}
+		})
+		return opts
+	}
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServerConfig holds the HTTP listener settings.
+type ServerConfig struct {
+	Port              int
+	AllowedOrigins    []string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxBodyBytes      int64
+	TLSCertFile       string
+	TLSKeyFile        string
+	// TrustedProxies are IPs or CIDRs whose X-Forwarded-For is believed
+	// when resolving client IPs. Empty trusts none.
+	TrustedProxies []string
+}
+
+// Addr is the listen address for http.Server.
+func (s ServerConfig) Addr() string {
+	return ":" + strconv.Itoa(s.Port)
+}
+
+// TLSEnabled reports whether the server should serve HTTPS.
+func (s ServerConfig) TLSEnabled() bool {
+	return s.TLSCertFile != ""
+}
+
+func (s ServerConfig) allowsAnyOrigin() bool {
+	for _, o := range s.AllowedOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func loadServer(e *env) ServerConfig {
+	return ServerConfig{
+		Port:           e.getInt("PORT", 8080),
+		AllowedOrigins: splitList(e.getString("CORS_ALLOWED_ORIGINS", "http://localhost:5173")),
+		TrustedProxies: splitList(e.get("TRUSTED_PROXIES")),
+		TLSCertFile:    e.get("TLS_CERT_FILE"),
+		TLSKeyFile:     e.get("TLS_KEY_FILE"),
+
+		ReadTimeout:       e.getDuration("SERVER_READ_TIMEOUT", 15*time.Second),
+		ReadHeaderTimeout: e.getDuration("SERVER_READ_HEADER_TIMEOUT", 5*time.Second),
+		// History and OHLC fan out to CoinGecko per coin, so allow for that
+		WriteTimeout: e.getDuration("SERVER_WRITE_TIMEOUT", 60*time.Second),
+		IdleTimeout:  e.getDuration("SERVER_IDLE_TIMEOUT", 120*time.Second),
+		MaxBodyBytes: e.getInt64("MAX_BODY_BYTES", 1<<20),
+	}
+}
+
+// validate checks the settings together, reporting every problem at once.
+func (s ServerConfig) validate() error {
+	var errs []error
+	if s.Port < 1 || s.Port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT must be between 1 and 65535, got %d", s.Port))
+	}
+	if len(s.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("CORS_ALLOWED_ORIGINS must list at least one origin"))
+	}
+	for _, o := range s.AllowedOrigins {
+		if o == "*" {
+			continue
+		}
+		if u, err := url.Parse(o); err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			errs = append(errs, fmt.Errorf("CORS_ALLOWED_ORIGINS entry %q must be * or scheme://host[:port]", o))
+		}
+	}
+	for name, d := range map[string]time.Duration{
+		"SERVER_READ_TIMEOUT":        s.ReadTimeout,
+		"SERVER_READ_HEADER_TIMEOUT": s.ReadHeaderTimeout,
+		"SERVER_WRITE_TIMEOUT":       s.WriteTimeout,
+		"SERVER_IDLE_TIMEOUT":        s.IdleTimeout,
+	} {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got %s", name, d))
+		}
+	}
+	if s.MaxBodyBytes <= 0 {
+		errs = append(errs, fmt.Errorf("MAX_BODY_BYTES must be positive, got %d", s.MaxBodyBytes))
+	}
+	if (s.TLSCertFile == "") != (s.TLSKeyFile == "") {
+		errs = append(errs, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+	for name, path := range map[string]string{"TLS_CERT_FILE": s.TLSCertFile, "TLS_KEY_FILE": s.TLSKeyFile} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	for _, p := range s.TrustedProxies {
+		if net.ParseIP(p) == nil {
+			if _, _, err := net.ParseCIDR(p); err != nil {
+				errs = append(errs, fmt.Errorf("TRUSTED_PROXIES entry %q is not an IP or CIDR", p))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// splitList parses a comma-separated list, dropping empty entries.
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"stock-talk-service/internal/lock"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// migrationLock names the advisory lock Migrate holds. Job locks share its
+// key space, so no scheduled job may use this name.
+const migrationLock = "schema_migrations"
+
+// Migrate applies any embedded migrations that are not yet recorded in
+// schema_migrations, in file name order, each in its own transaction. On
+// Postgres the whole run holds an advisory lock, so replicas starting
+// together apply each migration once; a replica that waited sees what the
+// holder recorded, since every migration re-checks schema_migrations.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, ok := db.Driver().(*pq.Driver); ok {
+		key := lock.AdvisoryKey(migrationLock)
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+			return fmt.Errorf("acquiring migration lock: %w", err)
+		}
+		defer func() {
+			if _, err := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", key); err != nil {
+				slog.ErrorContext(ctx, "releasing migration lock", "error", err)
+			}
+		}()
+	}
+
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version TEXT PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL
+		)
+	`); err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	names, err := fs.Glob(migrationFS, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := applyMigration(ctx, conn, name); err != nil {
+			return fmt.Errorf("applying %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, name string) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations WHERE version = $1", name).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	script, err := migrationFS.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)
+		ON CONFLICT (version) DO NOTHING
+	`, name, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "applied migration", "migration", name)
+	return nil
+}
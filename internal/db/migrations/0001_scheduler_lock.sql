@@ -0,0 +1,8 @@
+-- Coordinates scheduled jobs across replicas. Postgres uses advisory locks and
+-- only needs last_completed_at; other drivers lease the row via owner/expires_at.
+CREATE TABLE IF NOT EXISTS scheduler_lock (
+	name TEXT PRIMARY KEY,
+	owner TEXT NOT NULL DEFAULT '',
+	expires_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_completed_at TIMESTAMP
+);
@@ -0,0 +1,6 @@
+-- Bumped whenever a replica rewrites a cached table so the others reload.
+CREATE TABLE IF NOT EXISTS cache_version (
+	name TEXT PRIMARY KEY,
+	version BIGINT NOT NULL DEFAULT 0,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
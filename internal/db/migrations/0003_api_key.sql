@@ -0,0 +1,11 @@
+-- Issued API keys. Only a SHA-256 of the key is stored; prefix is the
+-- public part shown in listings so operators can tell keys apart.
+CREATE TABLE IF NOT EXISTS api_key (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	tier TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMP
+);
@@ -0,0 +1,18 @@
+-- One row per catalog sync. Failed runs keep the reason they were aborted
+-- so a partial download is visible instead of silently flooding the review
+-- queue.
+CREATE TABLE IF NOT EXISTS sync_run (
+	id TEXT PRIMARY KEY,
+	catalog TEXT NOT NULL,
+	status TEXT NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	existing_count INTEGER NOT NULL DEFAULT 0,
+	fetched_count INTEGER NOT NULL DEFAULT 0,
+	added_count INTEGER NOT NULL DEFAULT 0,
+	missing_count INTEGER NOT NULL DEFAULT 0,
+	changed_count INTEGER NOT NULL DEFAULT 0,
+	started_at TIMESTAMP NOT NULL,
+	finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS sync_run_catalog_started_idx ON sync_run (catalog, started_at);
@@ -0,0 +1,3 @@
+-- When the upstream generated the synced data (the NASDAQ File Creation Time
+-- trailer), so a feed that stopped updating shows up as stale runs.
+ALTER TABLE sync_run ADD COLUMN source_created_at TIMESTAMP;
@@ -0,0 +1,10 @@
+-- Consecutive syncs each catalog item has been missing from its feed. The
+-- sync policy only deactivates an item once this reaches SYNC_MISSING_RUNS;
+-- the row is dropped when the item reappears or is deactivated.
+CREATE TABLE IF NOT EXISTS catalog_missing (
+	catalog TEXT NOT NULL,
+	item_key TEXT NOT NULL,
+	runs INTEGER NOT NULL,
+	first_missed_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (catalog, item_key)
+);
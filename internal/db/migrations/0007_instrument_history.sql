@@ -0,0 +1,15 @@
+-- Ticker, name and active changes applied to stock and crypto rows, so old
+-- values survive a rename and old tickers can be resolved to the current
+-- instrument.
+CREATE TABLE IF NOT EXISTS instrument_history (
+	catalog TEXT NOT NULL,
+	instrument_id TEXT NOT NULL,
+	field TEXT NOT NULL,
+	old_value TEXT NOT NULL,
+	new_value TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	effective_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS instrument_history_instrument_idx ON instrument_history (catalog, instrument_id, effective_at);
+CREATE INDEX IF NOT EXISTS instrument_history_old_value_idx ON instrument_history (catalog, field, old_value);
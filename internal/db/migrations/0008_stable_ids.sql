@@ -0,0 +1,16 @@
+-- Stable stock identifiers. Row ids were regenerated whenever the catalog
+-- was reloaded; uid is assigned once per instrument and kept across reloads
+-- and ticker changes. Existing stocks get theirs at startup, since ULIDs
+-- can't be generated portably in SQL.
+ALTER TABLE stock ADD COLUMN uid TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS stock_uid_idx ON stock (uid);
+
+-- Watchlist items reference instruments by uid. watchlist_stock is
+-- backfilled once the stock uids exist; crypto uids come from the mapping
+-- file and are already there.
+ALTER TABLE watchlist_stock ADD COLUMN stock_uid TEXT;
+ALTER TABLE watchlist_crypto ADD COLUMN crypto_uid TEXT;
+UPDATE watchlist_crypto SET crypto_uid = (SELECT c.uid FROM crypto c WHERE c.id = watchlist_crypto.crypto_id)
+WHERE crypto_uid IS NULL;
+CREATE INDEX IF NOT EXISTS watchlist_stock_uid_idx ON watchlist_stock (stock_uid);
+CREATE INDEX IF NOT EXISTS watchlist_crypto_uid_idx ON watchlist_crypto (crypto_uid);
@@ -0,0 +1,2 @@
+-- The review sync joins stock against the staged feed by ticker.
+CREATE INDEX IF NOT EXISTS stock_ticker_idx ON stock (ticker);
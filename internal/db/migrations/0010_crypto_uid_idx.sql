@@ -0,0 +1,2 @@
+-- The review sync joins crypto against the staged feed by uid.
+CREATE INDEX IF NOT EXISTS crypto_uid_idx ON crypto (uid);
@@ -0,0 +1,4 @@
+-- Old tickers are looked up case-insensitively, which the plain old_value
+-- index can't serve.
+DROP INDEX IF EXISTS instrument_history_old_value_idx;
+CREATE INDEX IF NOT EXISTS instrument_history_old_value_upper_idx ON instrument_history (catalog, field, UPPER(old_value));
@@ -1,30 +1,261 @@
 package ftp_client
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"sync"
+	"time"
 
 	"github.com/jlaffaye/ftp"
 )
 
+// Retriever opens a file on an FTP server for reading. StockService depends
+// on this rather than *FTPClient so a local stand-in can serve the files.
+type Retriever interface {
+	RetrieveFile(ctx context.Context, path string) (io.ReadCloser, error)
+}
+
+// Passive data connection modes.
+const (
+	// PassiveAuto tries EPSV and falls back to PASV if the server rejects it.
+	PassiveAuto = "auto"
+	// PassivePASV always uses PASV, for servers or NATs that mishandle EPSV.
+	PassivePASV = "pasv"
+)
+
+// Options tunes how FTPClient connects and retries.
+type Options struct {
+	DialTimeout time.Duration
+	// ReadTimeout bounds each read or write on the control and data
+	// connections, so a stalled transfer fails instead of hanging the sync.
+	ReadTimeout  time.Duration
+	Retries      int
+	RetryBackoff time.Duration
+	PassiveMode  string
+	User         string
+	Password     string
+}
+
+// ErrClosed is returned by RetrieveFile once the client has been closed.
+var ErrClosed = errors.New("ftp client closed")
+
+// FTPClient dials a fresh connection for every retrieval. The NASDAQ server
+// drops idle sessions long before the next daily sync, so nothing is kept
+// open between calls, and a server that is down only fails the sync that
+// needs it.
 type FTPClient struct {
-    conn *ftp.ServerConn
+	addr string
+	opts Options
+
+	mu       sync.Mutex
+	sessions map[*connSet]struct{}
+	closed   bool
+}
+
+func NewFTPClient(addr string, opts Options) *FTPClient {
+	if opts.User == "" {
+		opts.User, opts.Password = "anonymous", "anonymous"
+	}
+	return &FTPClient{addr: addr, opts: opts, sessions: make(map[*connSet]struct{})}
+}
+
+// Close aborts every retrieval still open by closing its sockets, so a
+// transfer being read fails, and makes later retrievals fail with ErrClosed.
+func (c *FTPClient) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for conns := range c.sessions {
+		conns.closeAll()
+	}
+	clear(c.sessions)
 }
 
-func NewFTPClient(addr string) (*FTPClient, error) {
-    c, err := ftp.Dial(addr)
-    if err != nil {
-        return nil, err
-    }
-	if err := c.Login("anonymous", "anonymous"); err != nil {
+// track registers an open session for Close, reporting false once the
+// client is closed.
+func (c *FTPClient) track(conns *connSet) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.sessions[conns] = struct{}{}
+	return true
+}
+
+func (c *FTPClient) untrack(conns *connSet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.sessions, conns)
+}
+
+// RetrieveFile opens path for streaming, retrying the connect, login and
+// RETR with exponential backoff. Permanent replies such as 550 (no such
+// file) fail at once. The returned reader owns the connection; closing it
+// finishes the transfer and logs out. A transfer cut off part-way surfaces
+// as a read error or, for the symbol files, a missing trailer, so callers
+// never act on a truncated file.
+func (c *FTPClient) RetrieveFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	backoff := c.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		file, err := c.retrieve(ctx, path)
+		if err == nil {
+			return file, nil
+		}
+		if attempt >= c.opts.Retries || ctx.Err() != nil || permanent(err) || errors.Is(err, ErrClosed) {
+			return nil, fmt.Errorf("retrieving %s after %d attempt(s): %w", path, attempt+1, err)
+		}
+
+		slog.WarnContext(ctx, "ftp retrieve failed, retrying", "path", path, "attempt", attempt+1, "backoff", backoff, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("retrieving %s: %w", path, ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+// permanent reports a 5xx reply, which the server will repeat on retry.
+func permanent(err error) bool {
+	var reply *textproto.Error
+	return errors.As(err, &reply) && reply.Code >= 500 && reply.Code < 600
+}
+
+func (c *FTPClient) retrieve(ctx context.Context, path string) (_ io.ReadCloser, err error) {
+	conns := &connSet{}
+	if !c.track(conns) {
+		return nil, ErrClosed
+	}
+	conn, err := c.dial(ctx, conns)
+	if err != nil {
+		c.untrack(conns)
+		return nil, fmt.Errorf("connecting: %w", err)
+	}
+
+	// ServerConn isn't safe for concurrent use, so cancellation closes the
+	// sockets underneath it instead of sending QUIT from another goroutine.
+	// A blocked read then fails and the owning goroutine cleans up.
+	stop := context.AfterFunc(ctx, conns.closeAll)
+	defer func() {
+		if err != nil {
+			stop()
+			quit(ctx, conn)
+			c.untrack(conns)
+		}
+	}()
+
+	if err := conn.Login(c.opts.User, c.opts.Password); err != nil {
+		return nil, fmt.Errorf("login: %w", err)
+	}
+	resp, err := conn.Retr(path)
+	if err != nil {
 		return nil, err
 	}
-    return &FTPClient{conn: c}, nil
+	return &transfer{Response: resp, ctx: ctx, conn: conn, stop: stop, done: func() { c.untrack(conns) }}, nil
+}
+
+// transfer streams a RETR response and releases the connection on Close.
+type transfer struct {
+	*ftp.Response
+	ctx  context.Context
+	conn *ftp.ServerConn
+	stop func() bool
+	done func()
+}
+
+func (t *transfer) Read(b []byte) (int, error) {
+	n, err := t.Response.Read(b)
+	if err != nil && err != io.EOF && t.ctx.Err() != nil {
+		err = t.ctx.Err()
+	}
+	return n, err
 }
 
-func (c *FTPClient) RetrieveFile(path string) (io.ReadCloser, error) {
-    return c.conn.Retr(path)
+// Close reads the transfer's completion reply and logs out.
+func (t *transfer) Close() error {
+	err := t.Response.Close()
+	t.stop()
+	quit(t.ctx, t.conn)
+	t.done()
+	return err
 }
 
-func (c *FTPClient) Close() error {
-    return c.conn.Quit()
-}
\ No newline at end of file
+func quit(ctx context.Context, conn *ftp.ServerConn) {
+	if err := conn.Quit(); err != nil {
+		slog.DebugContext(ctx, "ftp quit failed", "error", err)
+	}
+}
+
+// connSet tracks the control and data sockets of one session so they can
+// be closed from another goroutine.
+type connSet struct {
+	mu     sync.Mutex
+	conns  []net.Conn
+	closed bool
+}
+
+func (s *connSet) add(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		conn.Close()
+		return
+	}
+	s.conns = append(s.conns, conn)
+}
+
+func (s *connSet) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (c *FTPClient) dial(ctx context.Context, conns *connSet) (*ftp.ServerConn, error) {
+	dialer := net.Dialer{Timeout: c.opts.DialTimeout}
+	return ftp.Dial(c.addr,
+		ftp.DialWithDisabledEPSV(c.opts.PassiveMode == PassivePASV),
+		// Used for the control and every data connection
+		ftp.DialWithDialFunc(func(network, address string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			conns.add(conn)
+			return &deadlineConn{Conn: conn, timeout: c.opts.ReadTimeout}, nil
+		}),
+	)
+}
+
+// deadlineConn pushes the deadline forward before every read and write, so
+// ReadTimeout limits stalls rather than the length of the whole transfer.
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	if c.timeout > 0 {
+		if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	if c.timeout > 0 {
+		if err := c.Conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Write(b)
+}
@@ -2,78 +2,173 @@ package handlers
 
 import (
 	"net/http"
+	"stock-talk-service/internal/apperrors"
 	"stock-talk-service/internal/models"
 	"stock-talk-service/internal/services"
+	"stock-talk-service/internal/validation"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 type CryptoGinHandler struct {
-	Service *services.CryptoService
+	Service    *services.CryptoService
+	validators *validatorCache
 }
 
 func NewCryptoGinHandler(service *services.CryptoService) *CryptoGinHandler {
-	return &CryptoGinHandler{Service: service}
+	return &CryptoGinHandler{Service: service, validators: newValidatorCache()}
 }
 
-// GET /crypto
+// GET /api/v1/crypto?include_inactive=true
 func (h *CryptoGinHandler) GetAllCrypto(ctx *gin.Context) {
-	crypto := h.Service.GetAllCrypto()
+	includeInactive, err := includeInactiveParam(ctx)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	crypto := h.Service.GetAllCrypto(includeInactive)
 	ctx.JSON(http.StatusOK, crypto)
 }
 
-// GET /crypto/:id
+// GET /api/v1/crypto/:id
 func (h *CryptoGinHandler) GetCryptoByID(ctx *gin.Context) {
 	id := ctx.Param("id")
 	crypto, ok := h.Service.GetCryptoByID(id)
 	if !ok {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "crypto not found"})
+		ctx.Error(apperrors.NotFound("crypto", id))
 		return
 	}
 	ctx.JSON(http.StatusOK, crypto)
 }
 
-// POST /crypto/price
+// GET /api/v1/crypto/:id/history-meta
+func (h *CryptoGinHandler) GetCryptoHistoryMeta(ctx *gin.Context) {
+	history, err := h.Service.GetCryptoHistoryMeta(ctx.Request.Context(), ctx.Param("id"))
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	ctx.JSON(http.StatusOK, history)
+}
+
+// POST /api/v1/crypto/prices
 func (h *CryptoGinHandler) GetCryptoPrice(ctx *gin.Context) {
 	var req models.CryptoPriceRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		ctx.Error(validation.BindingError(err))
 		return
 	}
-	result, err := h.Service.GetCryptoPrice(req.CoinIDs, req.VsCurrencies)
+	result, err := h.Service.GetCryptoPrice(ctx.Request.Context(), req.CoinIDs, req.VsCurrencies)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		ctx.Error(err)
 		return
 	}
 	ctx.JSON(http.StatusOK, result)
 }
 
-// POST /crypto/history
+// POST /api/v1/crypto/history
 func (h *CryptoGinHandler) GetCryptoHistory(ctx *gin.Context) {
 	var req models.CryptoHistoryRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		ctx.Error(validation.BindingError(err))
 		return
 	}
-	result, err := h.Service.GetCryptoHistory(req.CoinIDs, req.VsCurrency, req.Days, req.Interval)
+	result, err := h.Service.GetCryptoHistory(ctx.Request.Context(), req.CoinIDs, req.VsCurrency, req.Days, req.Interval)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		ctx.Error(err)
 		return
 	}
 	ctx.JSON(http.StatusOK, result)
 }
 
-// POST /crypto/history-ohlc
+// POST /api/v1/crypto/ohlc
 func (h *CryptoGinHandler) GetCryptoHistoryOHLC(ctx *gin.Context) {
 	var req models.CryptoHistoryOHLCRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		ctx.Error(validation.BindingError(err))
 		return
 	}
-	result, err := h.Service.GetCryptoHistoryOHLC(req.CoinIDs, req.VsCurrency, req.Days, req.Interval)
+	result, err := h.Service.GetCryptoHistoryOHLC(ctx.Request.Context(), req.CoinIDs, req.VsCurrency, req.Days, req.Interval)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		ctx.Error(err)
 		return
 	}
 	ctx.JSON(http.StatusOK, result)
-}
\ No newline at end of file
+}
+
+// GET /api/v1/crypto/prices?coin_ids=bitcoin,ethereum&vs_currencies=usd
+func (h *CryptoGinHandler) GetCryptoPriceQuery(ctx *gin.Context) {
+	var req models.CryptoPriceRequest
+	if err := bindQuery(ctx, &req, "coin_ids", "vs_currencies"); err != nil {
+		ctx.Error(validation.BindingError(err))
+		return
+	}
+	if h.validators.notModified(ctx) {
+		return
+	}
+	result, err := h.Service.GetCryptoPrice(ctx.Request.Context(), req.CoinIDs, req.VsCurrencies)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	h.validators.writeCacheable(ctx, result, priceUpdateInterval)
+}
+
+// GET /api/v1/crypto/history?coin_ids=bitcoin&vs_currency=usd&days=30
+func (h *CryptoGinHandler) GetCryptoHistoryQuery(ctx *gin.Context) {
+	var req models.CryptoHistoryRequest
+	if err := bindQuery(ctx, &req, "coin_ids"); err != nil {
+		ctx.Error(validation.BindingError(err))
+		return
+	}
+	if h.validators.notModified(ctx) {
+		return
+	}
+	result, err := h.Service.GetCryptoHistory(ctx.Request.Context(), req.CoinIDs, req.VsCurrency, req.Days, req.Interval)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	h.validators.writeCacheable(ctx, result, historyInterval(req.Days, req.Interval))
+}
+
+// GET /api/v1/crypto/ohlc?coin_ids=bitcoin&vs_currency=usd&days=30
+func (h *CryptoGinHandler) GetCryptoHistoryOHLCQuery(ctx *gin.Context) {
+	var req models.CryptoHistoryOHLCRequest
+	if err := bindQuery(ctx, &req, "coin_ids"); err != nil {
+		ctx.Error(validation.BindingError(err))
+		return
+	}
+	if h.validators.notModified(ctx) {
+		return
+	}
+	result, err := h.Service.GetCryptoHistoryOHLC(ctx.Request.Context(), req.CoinIDs, req.VsCurrency, req.Days, req.Interval)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	h.validators.writeCacheable(ctx, result, ohlcInterval(req.Days, req.Interval))
+}
+
+// bindQuery binds and validates query parameters into req. The listKeys
+// accept comma-separated values as well as repeated keys.
+func bindQuery(ctx *gin.Context, req any, listKeys ...string) error {
+	query := ctx.Request.URL.Query()
+	for _, key := range listKeys {
+		query[key] = splitList(query[key])
+	}
+	if err := binding.MapFormWithTag(req, query, "form"); err != nil {
+		return err
+	}
+	return binding.Validator.ValidateStruct(req)
+}
+
+func splitList(values []string) []string {
+	var out []string
+	for _, v := range values {
+		out = append(out, strings.Split(v, ",")...)
+	}
+	return out
+}
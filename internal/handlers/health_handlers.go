@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+	"stock-talk-service/internal/health"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HealthGinHandler struct {
+	checker *health.Checker
+}
+
+func NewHealthGinHandler(checker *health.Checker) *HealthGinHandler {
+	return &HealthGinHandler{checker: checker}
+}
+
+// GET /healthz
+func (h *HealthGinHandler) Liveness(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// GET /readyz
+func (h *HealthGinHandler) Readiness(ctx *gin.Context) {
+	report := h.checker.Check(ctx.Request.Context())
+	status := http.StatusOK
+	if report.Status != health.StatusReady {
+		status = http.StatusServiceUnavailable
+	}
+	ctx.JSON(status, report)
+}
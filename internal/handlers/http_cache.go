@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"stock-talk-service/internal/apperrors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// priceUpdateInterval is roughly how often CoinGecko refreshes simple prices.
+const priceUpdateInterval = time.Minute
+
+// maxValidators bounds the validator cache; when it is full of unexpired
+// entries new responses are served without being remembered.
+const maxValidators = 10000
+
+// cacheable is an upstream-backed response that can report its freshness.
+type cacheable interface {
+	// LastUpdated is when the upstream data last changed, or zero if unknown.
+	LastUpdated() time.Time
+	// Partial reports that part of the response is an error placeholder.
+	Partial() bool
+}
+
+// validatorCache remembers the ETag served for each cacheable GET until its
+// max-age runs out. A conditional request that still matches is answered
+// with a 304 before the handler spends upstream quota.
+type validatorCache struct {
+	mu      sync.Mutex
+	entries map[string]validator
+}
+
+type validator struct {
+	etag         string
+	lastModified time.Time
+	expires      time.Time
+}
+
+func newValidatorCache() *validatorCache {
+	return &validatorCache{entries: make(map[string]validator)}
+}
+
+// requestKey identifies a cacheable GET by path and query; Encode sorts the
+// parameters so their order does not matter.
+func requestKey(r *http.Request) string {
+	return r.URL.Path + "?" + r.URL.Query().Encode()
+}
+
+// notModified answers the request with a 304 when its If-None-Match or
+// If-Modified-Since still matches a fresh response for the same request,
+// with the same precedence writeCacheable applies.
+func (c *validatorCache) notModified(ctx *gin.Context) bool {
+	if ctx.GetHeader("If-None-Match") == "" && ctx.GetHeader("If-Modified-Since") == "" {
+		return false
+	}
+	c.mu.Lock()
+	v, ok := c.entries[requestKey(ctx.Request)]
+	c.mu.Unlock()
+	left := time.Until(v.expires).Truncate(time.Second)
+	if !ok || left <= 0 || !notModified(ctx.Request, v.etag, v.lastModified) {
+		return false
+	}
+	setValidators(ctx, v.etag, v.lastModified, left)
+	ctx.Status(http.StatusNotModified)
+	return true
+}
+
+func (c *validatorCache) store(key string, v validator) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxValidators {
+		now := time.Now()
+		for k, e := range c.entries {
+			if !e.expires.After(now) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxValidators {
+			return
+		}
+	}
+	c.entries[key] = v
+}
+
+// writeCacheable renders body as JSON with validators and a Cache-Control
+// lifetime that runs until the next expected upstream update. Requests whose
+// If-None-Match or If-Modified-Since still match get a bodiless 304. Partial
+// responses are marked no-store so a transient per-coin failure is not
+// served from caches for a whole update interval.
+func (c *validatorCache) writeCacheable(ctx *gin.Context, body cacheable, updateInterval time.Duration) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		ctx.Error(apperrors.Internal(err))
+		return
+	}
+	if body.Partial() {
+		ctx.Header("Cache-Control", "no-store")
+		ctx.Data(http.StatusOK, "application/json; charset=utf-8", payload)
+		return
+	}
+
+	sum := sha256.Sum256(payload)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	lastModified := body.LastUpdated()
+	age := maxAge(lastModified, updateInterval)
+	setValidators(ctx, etag, lastModified, age)
+	if age > 0 {
+		c.store(requestKey(ctx.Request), validator{etag: etag, lastModified: lastModified, expires: time.Now().Add(age)})
+	}
+
+	if notModified(ctx.Request, etag, lastModified) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+	ctx.Data(http.StatusOK, "application/json; charset=utf-8", payload)
+}
+
+func setValidators(ctx *gin.Context, etag string, lastModified time.Time, age time.Duration) {
+	h := ctx.Writer.Header()
+	h.Set("ETag", etag)
+	h.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(age.Seconds())))
+	// Shared caches must not answer a keyless request with a response that
+	// was fetched with a key, which matters when keys are required
+	h.Add("Vary", "X-API-Key, Authorization")
+	if !lastModified.IsZero() {
+		h.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// maxAge is the time left until the data is expected to change, capped at
+// one update interval. Without a timestamp the full interval is used.
+func maxAge(lastModified time.Time, updateInterval time.Duration) time.Duration {
+	if lastModified.IsZero() {
+		return updateInterval
+	}
+	left := time.Until(lastModified.Add(updateInterval))
+	switch {
+	case left < 0:
+		return 0
+	case left > updateInterval:
+		return updateInterval
+	}
+	return left.Truncate(time.Second)
+}
+
+// notModified applies RFC 9110 precedence: If-None-Match wins when present.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Header.Get("If-None-Match") != "" {
+		return etagMatches(r, etag)
+	}
+	if lastModified.IsZero() {
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !lastModified.Truncate(time.Second).After(ims)
+}
+
+// etagMatches reports whether any If-None-Match candidate matches etag,
+// using weak comparison.
+func etagMatches(r *http.Request, etag string) bool {
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// historyInterval is CoinGecko's market_chart granularity for a range:
+// 5-minutely for one day, hourly up to 90 days, daily beyond.
+func historyInterval(days, interval string) time.Duration {
+	switch interval {
+	case "5m":
+		return 5 * time.Minute
+	case "hourly":
+		return time.Hour
+	case "daily":
+		return 24 * time.Hour
+	}
+	n, err := strconv.Atoi(days)
+	switch {
+	case err != nil:
+		return 24 * time.Hour
+	case n <= 1:
+		return 5 * time.Minute
+	case n <= 90:
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// ohlcInterval is CoinGecko's candle size for a range: 30 minutes up to two
+// days, 4 hours up to 30 days, 4 days beyond.
+func ohlcInterval(days, interval string) time.Duration {
+	switch interval {
+	case "hourly":
+		return time.Hour
+	case "daily":
+		return 24 * time.Hour
+	}
+	n, err := strconv.Atoi(days)
+	switch {
+	case err != nil:
+		return 96 * time.Hour
+	case n <= 2:
+		return 30 * time.Minute
+	case n <= 30:
+		return 4 * time.Hour
+	}
+	return 96 * time.Hour
+}
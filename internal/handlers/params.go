@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"stock-talk-service/internal/apperrors"
+	"stock-talk-service/internal/validation"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// includeInactiveParam reads the include_inactive query flag used by the
+// catalog list endpoints. Absent means false.
+func includeInactiveParam(ctx *gin.Context) (bool, error) {
+	raw, ok := ctx.GetQuery("include_inactive")
+	if !ok || raw == "" {
+		return false, nil
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, apperrors.Validation("request validation failed", []validation.FieldError{{
+			Field:   "include_inactive",
+			Rule:    "boolean",
+			Message: "must be true or false",
+		}})
+	}
+	return v, nil
+}
+
+// invalidIDError reports a non-numeric :id path parameter.
+func invalidIDError() error {
+	return apperrors.Validation("request validation failed", []validation.FieldError{{
+		Field:   "id",
+		Rule:    "integer",
+		Message: "must be an integer",
+	}})
+}
@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"net/http"
+	"net/url"
 	"stock-talk-service/internal/services"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -15,21 +17,50 @@ func NewStockGinHandler(service *services.StockService) *StockGinHandler {
     return &StockGinHandler{Service: service}
 }
 
-// GET /stocks
+// GET /api/v1/stocks?include_inactive=true
 func (h *StockGinHandler) GetAllStocks(ctx *gin.Context) {
-    stocks := h.Service.GetAllStocks()
+    includeInactive, err := includeInactiveParam(ctx)
+    if err != nil {
+        ctx.Error(err)
+        return
+    }
+    stocks := h.Service.GetAllStocks(includeInactive)
     ctx.JSON(http.StatusOK, stocks)
 }
 
-// Optionally, you can keep these for single ticker lookups if needed:
-
-// GET /stocks/:ticker
+// GET /api/v1/stocks/:ticker
+// An old ticker resolves to the stock that now holds it; Content-Location
+// and a canonical Link point clients at the current ticker.
 func (h *StockGinHandler) GetStockByTicker(ctx *gin.Context) {
-    id := ctx.Param("id")
-    stock, ok := h.Service.GetStockById(id)
-    if !ok {
-        ctx.JSON(http.StatusNotFound, gin.H{"error": "stock not found"})
+    ticker := ctx.Param("ticker")
+    stock, err := h.Service.ResolveStock(ctx.Request.Context(), ticker)
+    if err != nil {
+        ctx.Error(err)
         return
     }
+    setRenameHint(ctx, ticker, stock.Ticker, "")
     ctx.JSON(http.StatusOK, stock)
-}
\ No newline at end of file
+}
+
+// GET /api/v1/stocks/:ticker/history
+func (h *StockGinHandler) GetStockHistory(ctx *gin.Context) {
+    ticker := ctx.Param("ticker")
+    history, err := h.Service.GetStockHistory(ctx.Request.Context(), ticker)
+    if err != nil {
+        ctx.Error(err)
+        return
+    }
+    setRenameHint(ctx, ticker, history.Stock.Ticker, "/history")
+    ctx.JSON(http.StatusOK, history)
+}
+
+// setRenameHint points clients at the current ticker when they asked for an
+// old one
+func setRenameHint(ctx *gin.Context, requested, current, suffix string) {
+    if strings.EqualFold(requested, current) {
+        return
+    }
+    location := "/api/v1/stocks/" + url.PathEscape(current) + suffix
+    ctx.Header("Content-Location", location)
+    ctx.Header("Link", "<"+location+">; rel=\"canonical\"")
+}
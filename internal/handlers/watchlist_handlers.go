@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"stock-talk-service/internal/models"
 	"stock-talk-service/internal/services"
+	"stock-talk-service/internal/validation"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -17,37 +18,37 @@ func NewWatchlistGinHandler(watchlistService *services.WatchlistService) *Watchl
     return &WatchlistHandler{watchlistService: watchlistService}
 }
 
-// GET /watchlists
+// GET /api/v1/watchlists
 func (h *WatchlistHandler) GetAllWatchlists(ctx *gin.Context) {
-    watchlists, err := h.watchlistService.GetAllWatchlists()
+    watchlists, err := h.watchlistService.GetAllWatchlists(ctx.Request.Context())
     if err != nil {
-        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        ctx.Error(err)
         return
     }
     ctx.JSON(http.StatusOK, watchlists)
 }
 
-// GET /watchlists/:id
+// GET /api/v1/watchlists/:id
 func (h *WatchlistHandler) GetWatchlistByID(ctx *gin.Context) {
     idStr := ctx.Param("id")
     id, err := strconv.Atoi(idStr)
     if err != nil {
-        ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+        ctx.Error(invalidIDError())
         return
     }
-    watchlist, err := h.watchlistService.GetWatchlistByID(id)
+    watchlist, err := h.watchlistService.GetWatchlistByID(ctx.Request.Context(), id)
     if err != nil {
-        ctx.JSON(http.StatusNotFound, gin.H{"error": "watchlist not found"})
+        ctx.Error(err)
         return
     }
     ctx.JSON(http.StatusOK, watchlist)
 }
 
-// POST /watchlist
+// POST /api/v1/watchlists
 func (h *WatchlistHandler) CreateWatchlist(ctx *gin.Context) {
 	var reqBody models.CreateWatchlistRequest
 	if err := ctx.ShouldBindJSON(&reqBody); err != nil {
-        ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        ctx.Error(validation.BindingError(err))
         return
     }
 
@@ -57,40 +58,45 @@ func (h *WatchlistHandler) CreateWatchlist(ctx *gin.Context) {
 	watchlistStocks := reqBody.Stocks
 	watchlistCrypto := reqBody.Crypto
 
-    if err := h.watchlistService.CreateWatchlist(&watchlist, &watchlistStocks, &watchlistCrypto); err != nil {
-        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+    if err := h.watchlistService.CreateWatchlist(ctx.Request.Context(), &watchlist, &watchlistStocks, &watchlistCrypto); err != nil {
+        ctx.Error(err)
         return
     }
+    ctx.Header("Location", ctx.Request.URL.Path+"/"+watchlist.Id)
     ctx.JSON(http.StatusCreated, watchlist)
 }
 
-// PUT /watchlists/:id
+// PUT /api/v1/watchlists/:id
 func (h *WatchlistHandler) UpdateWatchlist(ctx *gin.Context) {
     id := ctx.Param("id")
-    var w models.Watchlist
-    if err := ctx.ShouldBindJSON(&w); err != nil {
-        ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+        ctx.Error(invalidIDError())
         return
     }
-    w.Id = id
-    if err := h.watchlistService.UpdateWatchlist(&w); err != nil {
-        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+    var reqBody models.UpdateWatchlistRequest
+    if err := ctx.ShouldBindJSON(&reqBody); err != nil {
+        ctx.Error(validation.BindingError(err))
+        return
+    }
+    w := models.Watchlist{Id: id, Name: reqBody.Name}
+    if err := h.watchlistService.UpdateWatchlist(ctx.Request.Context(), &w); err != nil {
+        ctx.Error(err)
         return
     }
     ctx.JSON(http.StatusOK, w)
 }
 
-// DELETE /watchlists/:id
+// DELETE /api/v1/watchlists/:id
 func (h *WatchlistHandler) DeleteWatchlist(ctx *gin.Context) {
     idStr := ctx.Param("id")
     id, err := strconv.ParseInt(idStr, 10, 64)
     if err != nil {
-        ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+        ctx.Error(invalidIDError())
         return
     }
-    if err := h.watchlistService.DeleteWatchlist(id); err != nil {
-        ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+    if err := h.watchlistService.DeleteWatchlist(ctx.Request.Context(), id); err != nil {
+        ctx.Error(err)
         return
     }
-    ctx.JSON(http.StatusOK, gin.H{"status": "deleted"})
+    ctx.Status(http.StatusNoContent)
 }
\ No newline at end of file
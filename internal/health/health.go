@@ -0,0 +1,274 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+	// StatusUnknown marks a periodic check that has not completed yet.
+	StatusUnknown = "unknown"
+
+	StatusReady    = "ready"
+	StatusNotReady = "not_ready"
+)
+
+// Check is a single readiness probe. A failing Critical check makes the
+// instance not ready; non-critical failures are reported but tolerated.
+type Check struct {
+	Name     string
+	Critical bool
+	Run      func(ctx context.Context) (map[string]any, error)
+
+	// interval is set for checks added with AddPeriodic
+	interval time.Duration
+}
+
+// CheckResult is the public outcome of a check. Failure details are logged,
+// not returned, because the readiness endpoint is unauthenticated.
+type CheckResult struct {
+	Status    string         `json:"status"`
+	Critical  bool           `json:"critical"`
+	LatencyMs int64          `json:"latency_ms"`
+	CheckedAt *time.Time     `json:"checked_at"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+type Report struct {
+	Status   string                 `json:"status"`
+	Checks   map[string]CheckResult `json:"checks"`
+	LastSync map[string]*time.Time  `json:"last_sync"`
+}
+
+// Checker runs all registered checks concurrently with a shared timeout.
+// Periodic checks run in the background instead and are reported from their
+// last result, so probes never wait on them.
+type Checker struct {
+	checks   []Check
+	timeout  time.Duration
+	lastSync func(ctx context.Context) map[string]time.Time
+	jobNames []string
+
+	mu       sync.Mutex
+	periodic map[string]CheckResult
+	statuses map[string]string
+}
+
+// NewChecker creates a checker. lastSync reports when each job last
+// succeeded; jobNames lists the jobs to always include in the report.
+func NewChecker(timeout time.Duration, lastSync func(ctx context.Context) map[string]time.Time, jobNames ...string) *Checker {
+	return &Checker{
+		timeout:  timeout,
+		lastSync: lastSync,
+		jobNames: jobNames,
+		periodic: make(map[string]CheckResult),
+		statuses: make(map[string]string),
+	}
+}
+
+// Add registers a check that runs on every probe.
+func (c *Checker) Add(check Check) {
+	c.checks = append(c.checks, check)
+}
+
+// AddPeriodic registers a check that runs every interval once Start is
+// called. Use it for upstream dependencies that should not be hit on every
+// probe.
+func (c *Checker) AddPeriodic(check Check, interval time.Duration) {
+	check.interval = interval
+	c.checks = append(c.checks, check)
+}
+
+// Start runs the periodic checks until ctx is done.
+func (c *Checker) Start(ctx context.Context) {
+	for _, check := range c.checks {
+		if check.interval <= 0 {
+			continue
+		}
+		go func(check Check) {
+			ticker := time.NewTicker(check.interval)
+			defer ticker.Stop()
+			for {
+				runCtx, cancel := context.WithTimeout(ctx, c.timeout)
+				result := c.runCheck(runCtx, check)
+				cancel()
+
+				c.mu.Lock()
+				c.periodic[check.Name] = result
+				c.mu.Unlock()
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(check)
+	}
+}
+
+func (c *Checker) Check(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	report := Report{
+		Status:   StatusReady,
+		Checks:   make(map[string]CheckResult, len(c.checks)),
+		LastSync: make(map[string]*time.Time, len(c.jobNames)),
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, check := range c.checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+			var result CheckResult
+			if check.interval > 0 {
+				result = c.lastResult(check)
+			} else {
+				result = c.runCheck(ctx, check)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[check.Name] = result
+			if result.Status != StatusUp && check.Critical {
+				report.Status = StatusNotReady
+			}
+		}(check)
+	}
+	wg.Wait()
+
+	for _, name := range c.jobNames {
+		report.LastSync[name] = nil
+	}
+	if c.lastSync != nil {
+		for name, t := range c.lastSync(ctx) {
+			report.LastSync[name] = &t
+		}
+	}
+	return report
+}
+
+// lastResult returns the most recent result of a periodic check
+func (c *Checker) lastResult(check Check) CheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if result, ok := c.periodic[check.Name]; ok {
+		return result
+	}
+	return CheckResult{Status: StatusUnknown, Critical: check.Critical}
+}
+
+func (c *Checker) runCheck(ctx context.Context, check Check) CheckResult {
+	start := time.Now()
+	details, err := check.Run(ctx)
+	result := CheckResult{
+		Status:    StatusUp,
+		Critical:  check.Critical,
+		LatencyMs: time.Since(start).Milliseconds(),
+		CheckedAt: &start,
+		Details:   details,
+	}
+	if err != nil {
+		result.Status = StatusDown
+	}
+	c.logTransition(ctx, check, result.Status, err)
+	return result
+}
+
+// logTransition logs a check's failure detail when it goes down and again
+// when it recovers, rather than on every probe.
+func (c *Checker) logTransition(ctx context.Context, check Check, status string, err error) {
+	c.mu.Lock()
+	previous, seen := c.statuses[check.Name]
+	c.statuses[check.Name] = status
+	c.mu.Unlock()
+
+	switch {
+	case status == StatusDown && previous != StatusDown:
+		slog.WarnContext(ctx, "health check failed", "check", check.Name, "critical", check.Critical, "error", err)
+	case status == StatusUp && seen && previous != StatusUp:
+		slog.InfoContext(ctx, "health check recovered", "check", check.Name)
+	}
+}
+
+// DBCheck pings the database.
+func DBCheck(db *sql.DB) Check {
+	return Check{
+		Name:     "database",
+		Critical: true,
+		Run: func(ctx context.Context) (map[string]any, error) {
+			return nil, db.PingContext(ctx)
+		},
+	}
+}
+
+// TCPCheck verifies that addr accepts TCP connections.
+func TCPCheck(name, addr string, critical bool) Check {
+	return Check{
+		Name:     name,
+		Critical: critical,
+		Run: func(ctx context.Context) (map[string]any, error) {
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return nil, err
+			}
+			return nil, conn.Close()
+		},
+	}
+}
+
+// HTTPCheck verifies that url answers with a non-5xx status within timeout.
+func HTTPCheck(name, url string, timeout time.Duration, critical bool) Check {
+	client := &http.Client{Timeout: timeout}
+	return Check{
+		Name:     name,
+		Critical: critical,
+		Run: func(ctx context.Context) (map[string]any, error) {
+			req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+			if err != nil {
+				return nil, err
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+
+			details := map[string]any{"status_code": resp.StatusCode}
+			if resp.StatusCode >= http.StatusInternalServerError {
+				return details, fmt.Errorf("unexpected status %d", resp.StatusCode)
+			}
+			return details, nil
+		},
+	}
+}
+
+// CacheCheck fails while the cache reported by size is empty.
+func CacheCheck(name string, size func() int) Check {
+	return Check{
+		Name:     name,
+		Critical: true,
+		Run: func(ctx context.Context) (map[string]any, error) {
+			n := size()
+			details := map[string]any{"size": n}
+			if n == 0 {
+				return details, fmt.Errorf("cache is empty")
+			}
+			return details, nil
+		},
+	}
+}
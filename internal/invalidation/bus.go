@@ -0,0 +1,262 @@
+package invalidation
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Cache names published on the bus.
+const (
+	CacheStock  = "stock"
+	CacheCrypto = "crypto"
+)
+
+const channel = "cache_invalidation"
+
+// Publisher announces that a cached table changed.
+type Publisher interface {
+	// Bump advances name's version inside tx, the transaction writing the
+	// cached table, and returns the new version.
+	Bump(ctx context.Context, tx *sql.Tx, name string) (int64, error)
+	// Notify wakes listening replicas once the bump has committed.
+	Notify(ctx context.Context, name string) error
+	// Loaded records that this replica's cache already reflects version.
+	Loaded(name string, version int64)
+}
+
+// Commit commits tx, which wrote the table behind the named cache, then
+// notifies the other replicas and reloads the local cache. The version bump
+// commits with the write, so replicas that miss the notification still
+// reload on their next poll. publisher may be nil when running a single
+// replica.
+func Commit(ctx context.Context, tx *sql.Tx, publisher Publisher, name string, reload func(ctx context.Context) error) error {
+	if publisher == nil {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return reload(ctx)
+	}
+
+	version, err := publisher.Bump(ctx, tx, name)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if err := publisher.Notify(ctx, name); err != nil {
+		slog.WarnContext(ctx, "failed to notify cache invalidation", "cache", name, "error", err)
+	}
+	if err := reload(ctx); err != nil {
+		return err
+	}
+	publisher.Loaded(name, version)
+	return nil
+}
+
+// Bus propagates cache invalidations between replicas. Every catalog write
+// bumps a row in cache_version in its own transaction; on Postgres a NOTIFY
+// after the commit lets listeners react immediately. All replicas additionally poll cache_version, which is
+// the only mechanism on other drivers and catches missed notifications.
+type Bus struct {
+	db           *sql.DB
+	connString   string
+	pollInterval time.Duration
+	instanceID   string
+
+	mu       sync.Mutex
+	handlers map[string]func(ctx context.Context) error
+	versions map[string]int64
+
+	listener *pq.Listener
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// NewBus creates a bus. connString is used for the dedicated LISTEN
+// connection and is ignored for non-Postgres databases.
+func NewBus(db *sql.DB, connString string, pollInterval time.Duration) *Bus {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return &Bus{
+		db:           db,
+		connString:   connString,
+		pollInterval: pollInterval,
+		instanceID:   hex.EncodeToString(b),
+		handlers:     make(map[string]func(ctx context.Context) error),
+		versions:     make(map[string]int64),
+	}
+}
+
+// Subscribe registers fn to run when another replica invalidates name.
+// Must be called before Start.
+func (b *Bus) Subscribe(name string, fn func(ctx context.Context) error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[name] = fn
+}
+
+func (b *Bus) Bump(ctx context.Context, tx *sql.Tx, name string) (int64, error) {
+	var version int64
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO cache_version (name, version, updated_at) VALUES ($1, 1, $2)
+		ON CONFLICT (name) DO UPDATE SET version = cache_version.version + 1, updated_at = excluded.updated_at
+		RETURNING version
+	`, name, time.Now().UTC()).Scan(&version)
+	return version, err
+}
+
+func (b *Bus) Notify(ctx context.Context, name string) error {
+	if !b.isPostgres() {
+		return nil
+	}
+	_, err := b.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, name+"|"+b.instanceID)
+	return err
+}
+
+// Loaded keeps the poll from reloading a cache for our own bump. A poll
+// that already saw a newer version is left alone.
+func (b *Bus) Loaded(name string, version int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if current, ok := b.versions[name]; !ok || current < version {
+		b.versions[name] = version
+	}
+}
+
+// Start snapshots the current versions and begins listening and polling.
+func (b *Bus) Start(ctx context.Context) error {
+	versions, err := b.loadVersions(ctx)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.versions = versions
+	b.mu.Unlock()
+
+	var notifications <-chan *pq.Notification
+	if b.isPostgres() && b.connString != "" {
+		b.listener = pq.NewListener(b.connString, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				slog.Warn("cache invalidation listener error", "error", err)
+			}
+		})
+		if err := b.listener.Listen(channel); err != nil {
+			b.listener.Close()
+			return err
+		}
+		notifications = b.listener.NotificationChannel()
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	b.done = make(chan struct{})
+	go b.loop(runCtx, notifications)
+	return nil
+}
+
+// Close stops listening and polling.
+func (b *Bus) Close() error {
+	if b.cancel == nil {
+		return nil
+	}
+	b.cancel()
+	<-b.done
+	if b.listener != nil {
+		return b.listener.Close()
+	}
+	return nil
+}
+
+func (b *Bus) loop(ctx context.Context, notifications <-chan *pq.Notification) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-notifications:
+			if n == nil {
+				// Listener reconnected; notifications may have been missed.
+				b.poll(ctx)
+				continue
+			}
+			name, origin, _ := strings.Cut(n.Extra, "|")
+			if origin == b.instanceID {
+				continue
+			}
+			b.poll(ctx, name)
+		case <-ticker.C:
+			b.poll(ctx)
+		}
+	}
+}
+
+// poll reloads every cache (or only those in names) whose version moved.
+func (b *Bus) poll(ctx context.Context, names ...string) {
+	versions, err := b.loadVersions(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "cache invalidation poll failed", "error", err)
+		return
+	}
+
+	for name, version := range versions {
+		if len(names) > 0 && !slices.Contains(names, name) {
+			continue
+		}
+
+		b.mu.Lock()
+		changed := b.versions[name] != version
+		b.versions[name] = version
+		fn := b.handlers[name]
+		b.mu.Unlock()
+
+		if !changed || fn == nil {
+			continue
+		}
+		slog.InfoContext(ctx, "cache invalidated by another replica, reloading", "cache", name)
+		if err := fn(ctx); err != nil {
+			slog.ErrorContext(ctx, "reloading cache failed", "cache", name, "error", err)
+			// Forget the version so the next poll retries.
+			b.mu.Lock()
+			delete(b.versions, name)
+			b.mu.Unlock()
+		}
+	}
+}
+
+func (b *Bus) loadVersions(ctx context.Context) (map[string]int64, error) {
+	rows, err := b.db.QueryContext(ctx, "SELECT name, version FROM cache_version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var version int64
+		if err := rows.Scan(&name, &version); err != nil {
+			return nil, err
+		}
+		versions[name] = version
+	}
+	return versions, rows.Err()
+}
+
+func (b *Bus) isPostgres() bool {
+	_, ok := b.db.Driver().(*pq.Driver)
+	return ok
+}
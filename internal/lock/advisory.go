@@ -0,0 +1,53 @@
+package lock
+
+import (
+	"context"
+	"database/sql"
+	"hash/fnv"
+	"time"
+)
+
+// advisoryLocker uses session-level pg_try_advisory_lock. The lock is tied
+// to a dedicated connection, so it is released automatically if the holder
+// dies and ttl is not needed.
+type advisoryLocker struct {
+	runLog
+}
+
+type advisoryLock struct {
+	conn *sql.Conn
+	key  int64
+}
+
+func (l *advisoryLocker) TryAcquire(ctx context.Context, name string, _ time.Duration) (Lock, bool, error) {
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	key := AdvisoryKey(name)
+	var ok bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&ok); err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	if !ok {
+		conn.Close()
+		return nil, false, nil
+	}
+	return &advisoryLock{conn: conn, key: key}, true, nil
+}
+
+func (l *advisoryLock) Release(ctx context.Context) error {
+	defer l.conn.Close()
+	_, err := l.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.key)
+	return err
+}
+
+// AdvisoryKey is the Postgres advisory lock key for name. Job locks and the
+// migration lock both derive their keys here so they share one namespace.
+func AdvisoryKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("stock-talk-service:" + name))
+	return int64(h.Sum64())
+}
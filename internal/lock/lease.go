@@ -0,0 +1,46 @@
+package lock
+
+import (
+	"context"
+	"time"
+)
+
+// leaseLocker claims a scheduler_lock row until expires_at. It only relies on
+// INSERT ... ON CONFLICT, so it works on SQLite as well as Postgres.
+type leaseLocker struct {
+	runLog
+	owner string
+}
+
+type leaseLock struct {
+	locker *leaseLocker
+	name   string
+}
+
+func (l *leaseLocker) TryAcquire(ctx context.Context, name string, ttl time.Duration) (Lock, bool, error) {
+	now := time.Now().UTC()
+	res, err := l.db.ExecContext(ctx, `
+		INSERT INTO scheduler_lock (name, owner, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET owner = excluded.owner, expires_at = excluded.expires_at
+		WHERE scheduler_lock.expires_at < $4 OR scheduler_lock.owner = excluded.owner
+	`, name, l.owner, now.Add(ttl), now)
+	if err != nil {
+		return nil, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+	if n == 0 {
+		return nil, false, nil
+	}
+	return &leaseLock{locker: l, name: name}, true, nil
+}
+
+func (l *leaseLock) Release(ctx context.Context) error {
+	_, err := l.locker.db.ExecContext(ctx, `
+		UPDATE scheduler_lock SET owner = '', expires_at = $1
+		WHERE name = $2 AND owner = $3
+	`, time.Now().UTC(), l.name, l.locker.owner)
+	return err
+}
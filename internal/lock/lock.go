@@ -0,0 +1,70 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Lock is a held distributed lock.
+type Lock interface {
+	Release(ctx context.Context) error
+}
+
+// Locker hands out named locks shared by every replica using the same
+// database, and records when a named job last completed.
+type Locker interface {
+	// TryAcquire returns ok=false without blocking if another replica holds
+	// the lock. ttl bounds how long a crashed holder can keep it.
+	TryAcquire(ctx context.Context, name string, ttl time.Duration) (Lock, bool, error)
+	LastCompleted(ctx context.Context, name string) (time.Time, error)
+	MarkCompleted(ctx context.Context, name string, at time.Time) error
+}
+
+// New returns a Postgres advisory-lock Locker for lib/pq connections and a
+// lease-table Locker for any other driver (e.g. SQLite).
+func New(db *sql.DB) Locker {
+	runs := runLog{db: db}
+	if _, ok := db.Driver().(*pq.Driver); ok {
+		return &advisoryLocker{runLog: runs}
+	}
+	return &leaseLocker{runLog: runs, owner: newOwnerID()}
+}
+
+// runLog stores completion times in scheduler_lock.last_completed_at.
+type runLog struct {
+	db *sql.DB
+}
+
+func (r runLog) LastCompleted(ctx context.Context, name string) (time.Time, error) {
+	var last sql.NullTime
+	err := r.db.QueryRowContext(ctx, "SELECT last_completed_at FROM scheduler_lock WHERE name = $1", name).Scan(&last)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return last.Time, nil
+}
+
+func (r runLog) MarkCompleted(ctx context.Context, name string, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO scheduler_lock (name, last_completed_at) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET last_completed_at = excluded.last_completed_at
+	`, name, at.UTC())
+	return err
+}
+
+func newOwnerID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}
@@ -0,0 +1,118 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader is read from incoming requests and sent on outbound ones.
+const RequestIDHeader = "X-Request-ID"
+
+type ctxKey int
+
+const (
+	requestIDKey ctxKey = iota
+	jobKey
+)
+
+// Setup installs a JSON (or text) slog handler at the given level as the
+// default logger. The standard log package is routed through it too.
+func Setup(level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	var (
+		out  io.Writer = os.Stdout
+		opts           = &slog.HandlerOptions{Level: lvl}
+		base slog.Handler
+	)
+	switch strings.ToLower(format) {
+	case "", "json":
+		base = slog.NewJSONHandler(out, opts)
+	case "text":
+		base = slog.NewTextHandler(out, opts)
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+
+	slog.SetDefault(slog.New(&contextHandler{Handler: base}))
+	return nil
+}
+
+// contextHandler adds request_id, job and trace_id attributes carried in the
+// context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if job, ok := ctx.Value(jobKey).(string); ok {
+		r.AddAttrs(slog.String("job", job))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// NewRequestID returns a random 16-byte hex identifier.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithJob tags ctx with a scheduled job name and a fresh run ID.
+func WithJob(ctx context.Context, name string) context.Context {
+	ctx = context.WithValue(ctx, jobKey, name)
+	return WithRequestID(ctx, NewRequestID())
+}
+
+// Transport forwards the context's request ID on outbound requests.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if id := RequestID(req.Context()); id != "" && req.Header.Get(RequestIDHeader) == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(RequestIDHeader, id)
+	}
+	return base.RoundTrip(req)
+}
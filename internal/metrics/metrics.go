@@ -0,0 +1,210 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path"
+	"stock-talk-service/internal/logging"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+const namespace = "stock_talk"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Outbound request latency to CoinGecko and the NASDAQ FTP server.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"upstream", "operation", "outcome"})
+
+	upstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_errors_total",
+		Help:      "Failed outbound requests, by upstream and operation.",
+	}, []string{"upstream", "operation"})
+
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests rejected by the API rate limiter, by tier.",
+	}, []string{"tier"})
+
+	jobRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_runs_total",
+		Help:      "Scheduled job runs, by job and outcome.",
+	}, []string{"job", "outcome"})
+
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "Scheduled job run time.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800},
+	}, []string{"job"})
+)
+
+// Upstream names used as metric labels.
+const (
+	UpstreamCoinGecko = "coingecko"
+	UpstreamFTP       = "nasdaq_ftp"
+)
+
+// Job outcomes used as metric labels.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomePanic   = "panic"
+)
+
+// Handler serves the Prometheus scrape endpoint.
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// Middleware records request counts and latency. Routes are labelled by
+// their gin pattern (e.g. /crypto/:id) to keep cardinality bounded.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		httpDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveUpstream records one outbound call.
+func ObserveUpstream(upstream, operation string, start time.Time, err error) {
+	outcome := OutcomeSuccess
+	if err != nil {
+		outcome = OutcomeFailure
+		upstreamErrors.WithLabelValues(upstream, operation).Inc()
+	}
+	upstreamDuration.WithLabelValues(upstream, operation, outcome).Observe(time.Since(start).Seconds())
+}
+
+// ObserveRateLimited records one request rejected with 429.
+func ObserveRateLimited(tier string) {
+	rateLimited.WithLabelValues(tier).Inc()
+}
+
+// ObserveJob records one scheduled job run.
+func ObserveJob(job, outcome string, duration time.Duration) {
+	jobRuns.WithLabelValues(job, outcome).Inc()
+	jobDuration.WithLabelValues(job).Observe(duration.Seconds())
+}
+
+// RegisterCacheSize exposes the size of an in-process cache as a gauge.
+func RegisterCacheSize(cache string, size func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "cache_entries",
+		Help:        "Entries in an in-process cache.",
+		ConstLabels: prometheus.Labels{"cache": cache},
+	}, func() float64 { return float64(size()) })
+}
+
+// RegisterPendingReviews exposes unresolved review counts, queried on each
+// scrape with a short timeout. A failed query skips the sample.
+func RegisterPendingReviews(counters map[string]func(ctx context.Context) (int, error)) {
+	prometheus.MustRegister(&pendingReviewCollector{counters: counters})
+}
+
+var pendingReviewsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "pending_reviews"),
+	"Unresolved catalog review items, by asset class.",
+	[]string{"asset"}, nil,
+)
+
+type pendingReviewCollector struct {
+	counters map[string]func(ctx context.Context) (int, error)
+}
+
+func (c *pendingReviewCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pendingReviewsDesc
+}
+
+func (c *pendingReviewCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for asset, count := range c.counters {
+		n, err := count(ctx)
+		if err != nil {
+			slog.WarnContext(ctx, "counting pending reviews failed", "asset", asset, "error", err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(pendingReviewsDesc, prometheus.GaugeValue, float64(n), asset)
+	}
+}
+
+var errUpstreamStatus = errors.New("upstream returned an error status")
+
+// InstrumentedTransport wraps an http.RoundTripper so every request is
+// recorded against upstream, labelled by the last URL path segment
+// (e.g. "price", "market_chart", "ohlc").
+type InstrumentedTransport struct {
+	Upstream string
+	Base     http.RoundTripper
+}
+
+func (t *InstrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+
+	observed := err
+	if err == nil && resp.StatusCode >= http.StatusBadRequest {
+		observed = errUpstreamStatus
+	}
+	ObserveUpstream(t.Upstream, lastSegment(req.URL.Path), start, observed)
+	return resp, err
+}
+
+// NewUpstreamClient returns an http.Client that records metrics for upstream,
+// traces each call and forwards the caller's request ID and trace context.
+func NewUpstreamClient(upstream string) *http.Client {
+	return &http.Client{Transport: &InstrumentedTransport{
+		Upstream: upstream,
+		Base:     &logging.Transport{Base: otelhttp.NewTransport(http.DefaultTransport)},
+	}}
+}
+
+func lastSegment(p string) string {
+	seg := path.Base(p)
+	if seg == "." || seg == "/" {
+		return "root"
+	}
+	return seg
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"context"
+	"stock-talk-service/internal/apperrors"
+	"stock-talk-service/internal/config"
+	"stock-talk-service/internal/models"
+	"stock-talk-service/internal/ratelimit"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader carries the client's API key. "Authorization: Bearer <key>"
+// is accepted too.
+const APIKeyHeader = "X-API-Key"
+
+const apiKeyContextKey = "api_key"
+
+// Authenticator resolves a presented key to its record. Verified reports,
+// from memory only, whether the key is already known to be valid.
+type Authenticator interface {
+	Authenticate(ctx context.Context, key string) (*models.APIKey, error)
+	Verified(key string) bool
+}
+
+// APIKeyAuth authenticates the request's API key, if any, and stores it for
+// RateLimit and handlers. A presented key that doesn't resolve is always
+// rejected; requests without a key pass as anonymous unless required is set.
+//
+// A key that isn't already verified costs a token from the client IP's
+// bucket in anonymous before it is looked up, so clients cycling through
+// made-up keys are limited like anonymous ones instead of reaching the
+// database on every request.
+func APIKeyAuth(auth Authenticator, required bool, anonymous *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		presented := c.GetHeader(APIKeyHeader)
+		if presented == "" {
+			if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+				presented = strings.TrimSpace(token)
+			}
+		}
+
+		if presented == "" {
+			if required {
+				c.Error(apperrors.Unauthorized("an API key is required"))
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if !auth.Verified(presented) && !charge(c, anonymous, config.TierAnonymous, "ip:"+c.ClientIP()) {
+			return
+		}
+		key, err := auth.Authenticate(c.Request.Context(), presented)
+		if err != nil {
+			c.Error(err)
+			c.Abort()
+			return
+		}
+		c.Set(apiKeyContextKey, key)
+		c.Next()
+	}
+}
+
+// APIKey returns the authenticated key, or nil for anonymous requests.
+func APIKey(c *gin.Context) *models.APIKey {
+	if v, ok := c.Get(apiKeyContextKey); ok {
+		return v.(*models.APIKey)
+	}
+	return nil
+}
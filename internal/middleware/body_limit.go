@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+	"stock-talk-service/internal/apperrors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BodyLimit rejects request bodies larger than limit bytes. Declared
+// lengths are checked up front; chunked bodies fail when binding reads
+// past the limit.
+func BodyLimit(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			c.Error(apperrors.TooLarge(limit))
+			c.Abort()
+			return
+		}
+		if c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"stock-talk-service/internal/apperrors"
+	"stock-talk-service/internal/logging"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorResponse is the JSON body of every non-2xx API response.
+type ErrorResponse struct {
+	Code      apperrors.Code `json:"code"`
+	Message   string         `json:"message"`
+	Details   any            `json:"details,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+}
+
+// Errors renders the last error a handler attached with c.Error. Handlers
+// should attach an *apperrors.Error; anything else becomes an opaque 500 so
+// driver and upstream messages never reach clients.
+func Errors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		WriteError(c, c.Errors.Last().Err)
+	}
+}
+
+// WriteError aborts the request with the envelope for err.
+func WriteError(c *gin.Context, err error) {
+	appErr := apperrors.From(err)
+	status := appErr.Status()
+
+	if status >= http.StatusInternalServerError {
+		slog.ErrorContext(c.Request.Context(), "request failed", "code", appErr.Code, "error", err)
+	}
+	if appErr.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(appErr.RetryAfter.Seconds()))))
+	}
+
+	c.AbortWithStatusJSON(status, ErrorResponse{
+		Code:      appErr.Code,
+		Message:   appErr.Message,
+		Details:   appErr.Details,
+		RequestID: logging.RequestID(c.Request.Context()),
+	})
+}
+
+// NoRoute renders unknown paths with the standard envelope.
+func NoRoute(c *gin.Context) {
+	WriteError(c, &apperrors.Error{Code: apperrors.CodeNotFound, Message: "route not found"})
+}
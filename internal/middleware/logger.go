@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"stock-talk-service/internal/apperrors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Logger writes one structured access log line per request. It must run
+// after RequestID so the line carries the request ID.
+func Logger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.Int("bytes", c.Writer.Size()),
+		}
+		if key := APIKey(c); key != nil {
+			attrs = append(attrs, slog.String("api_key", key.Prefix))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("errors", c.Errors.String()))
+		}
+		slog.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// Recovery turns panics into a logged 500 with the standard error envelope
+// instead of gin's plain-text dump.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err any) {
+		slog.ErrorContext(c.Request.Context(), "panic while handling request", "panic", err)
+		WriteError(c, apperrors.Internal(fmt.Errorf("panic: %v", err)))
+	})
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"math"
+	"stock-talk-service/internal/apperrors"
+	"stock-talk-service/internal/config"
+	"stock-talk-service/internal/metrics"
+	"stock-talk-service/internal/ratelimit"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit applies a token bucket per API key, using the key's tier, or per
+// client IP at the anonymous tier. It must run after APIKeyAuth. Every
+// response carries RateLimit-* headers; exhausted buckets get a 429 with
+// Retry-After.
+func RateLimit(limiters map[string]*ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tier, bucket := config.TierAnonymous, "ip:"+c.ClientIP()
+		if key := APIKey(c); key != nil {
+			tier, bucket = key.Tier, "key:"+key.Id
+		}
+		limiter, ok := limiters[tier]
+		if !ok {
+			// A key whose tier was removed from config gets the lowest tier
+			tier = config.TierAnonymous
+			limiter = limiters[tier]
+		}
+
+		if charge(c, limiter, tier, bucket) {
+			c.Next()
+		}
+	}
+}
+
+// charge takes a token from bucket and sets the RateLimit-* headers. When
+// the bucket is empty it aborts with a 429 and returns false.
+func charge(c *gin.Context, limiter *ratelimit.Limiter, tier, bucket string) bool {
+	res := limiter.Allow(bucket)
+	h := c.Writer.Header()
+	h.Set("RateLimit-Policy", limiter.Policy())
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+	if !res.Allowed {
+		metrics.ObserveRateLimited(tier)
+		c.Error(apperrors.RateLimited("rate limit exceeded for tier "+tier, res.RetryAfter))
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
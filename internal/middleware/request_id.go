@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"stock-talk-service/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRequestIDLength caps client-supplied IDs so they can't bloat logs.
+const maxRequestIDLength = 128
+
+// RequestID propagates X-Request-ID, generating one when absent, into the
+// request context and the response headers.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(logging.RequestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = logging.NewRequestID()
+		}
+
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Header(logging.RequestIDHeader, id)
+		c.Next()
+	}
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type APIKey struct {
+	Id        string     `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Tier      string     `json:"tier"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type Crypto struct {
 	Id string `json:"id"`
 	Uid string `json:"uid"`
@@ -29,23 +31,26 @@ func (cj CryptoJSON) ToCrypto() Crypto {
     }
 }
 
+// Request limits keep a single call from fanning out into too many
+// CoinGecko requests. The form tags bind the GET query-string variants,
+// where lists may be comma-separated.
 type CryptoPriceRequest struct {
-	CoinIDs      []string `json:"coin_ids" binding:"required"`
-	VsCurrencies []string `json:"vs_currencies" binding:"required"`
+	CoinIDs      []string `json:"coin_ids" form:"coin_ids" binding:"required,min=1,max=50,dive,required,max=100"`
+	VsCurrencies []string `json:"vs_currencies" form:"vs_currencies" binding:"required,min=1,max=10,dive,required,alpha,max=10"`
 }
 
 type CryptoHistoryRequest struct {
-	CoinIDs    []string `json:"coin_ids" binding:"required"`
-	VsCurrency string   `json:"vs_currency" binding:"required"`
-	Days       string   `json:"days" binding:"required"`
-	Interval   string   `json:"interval"`
+	CoinIDs    []string `json:"coin_ids" form:"coin_ids" binding:"required,min=1,max=10,dive,required,max=100"`
+	VsCurrency string   `json:"vs_currency" form:"vs_currency" binding:"required,alpha,max=10"`
+	Days       string   `json:"days" form:"days" binding:"required,days"`
+	Interval   string   `json:"interval" form:"interval" binding:"omitempty,oneof=5m hourly daily"`
 }
 
 type CryptoHistoryOHLCRequest struct {
-	CoinIDs    []string `json:"coin_ids"`
-	VsCurrency string   `json:"vs_currency"`
-	Days       string   `json:"days"`
-	Interval   string   `json:"interval"`
+	CoinIDs    []string `json:"coin_ids" form:"coin_ids" binding:"required,min=1,max=10,dive,required,max=100"`
+	VsCurrency string   `json:"vs_currency" form:"vs_currency" binding:"required,alpha,max=10"`
+	Days       string   `json:"days" form:"days" binding:"required,days"`
+	Interval   string   `json:"interval" form:"interval" binding:"omitempty,oneof=hourly daily"`
 }
 
 type CryptoPriceResponse struct {
@@ -90,4 +95,88 @@ type CryptoReviewInsert struct {
 	Ticker      string
 	Name        string
 	Reason      string
-}
\ No newline at end of file
+}
+
+// LastUpdated returns the newest CoinGecko last_updated_at across coins, or
+// the zero time when none was reported.
+func (r *CryptoPriceResponse) LastUpdated() time.Time {
+	var latest time.Time
+	for _, v := range r.Prices {
+		coin, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if secs, ok := coin["last_updated_at"].(float64); ok {
+			if t := time.Unix(int64(secs), 0); t.After(latest) {
+				latest = t
+			}
+		}
+	}
+	return latest
+}
+
+// LastUpdated returns the timestamp of the newest price point across coins.
+func (r *CryptoHistoryResponse) LastUpdated() time.Time {
+	var latest time.Time
+	for _, d := range r.Data {
+		if t := lastPointTime(d.Prices); t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// LastUpdated returns the open time of the newest candle across coins.
+func (r *CryptoHistoryOHLCResponse) LastUpdated() time.Time {
+	var latest time.Time
+	for _, d := range r.Data {
+		if t := lastPointTime(d.OHLC); t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// Partial is always false: prices come from a single upstream call, so a
+// response either has every coin CoinGecko knows or is an error.
+func (r *CryptoPriceResponse) Partial() bool {
+	return false
+}
+
+// Partial reports whether fetching any coin's history failed.
+func (r *CryptoHistoryResponse) Partial() bool {
+	for _, d := range r.Data {
+		if d.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Partial reports whether fetching any coin's candles failed.
+func (r *CryptoHistoryOHLCResponse) Partial() bool {
+	for _, d := range r.Data {
+		if d.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// lastPointTime reads the millisecond timestamp of the last [ts, ...] entry
+// of a decoded CoinGecko series.
+func lastPointTime(series interface{}) time.Time {
+	points, ok := series.([]interface{})
+	if !ok || len(points) == 0 {
+		return time.Time{}
+	}
+	point, ok := points[len(points)-1].([]interface{})
+	if !ok || len(point) == 0 {
+		return time.Time{}
+	}
+	ms, ok := point[0].(float64)
+	if !ok {
+		return time.Time{}
+	}
+	return time.UnixMilli(int64(ms))
+}
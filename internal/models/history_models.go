@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Instrument fields tracked in instrument_history.
+const (
+	ChangeFieldTicker = "ticker"
+	ChangeFieldName   = "name"
+	ChangeFieldActive = "active"
+)
+
+// InstrumentChange is one applied change to a stock or crypto row.
+type InstrumentChange struct {
+	Field       string    `json:"field"`
+	OldValue    string    `json:"old_value"`
+	NewValue    string    `json:"new_value"`
+	Reason      string    `json:"reason"`
+	EffectiveAt time.Time `json:"effective_at"`
+}
+
+type StockHistory struct {
+	Stock   Stock              `json:"stock"`
+	Changes []InstrumentChange `json:"changes"`
+}
+
+type CryptoHistoryMeta struct {
+	Crypto  Crypto             `json:"crypto"`
+	Changes []InstrumentChange `json:"changes"`
+}
@@ -2,8 +2,10 @@ package models
 
 type Stock struct {
 	Id string `json:"id"`
+	Uid    string `json:"uid"`
 	Ticker string `json:"ticker"`
 	Name   string `json:"name"`
+	Active bool   `json:"active"`
 }
 
 type StockReviewInsert struct {
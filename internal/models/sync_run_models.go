@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Sync run statuses.
+const (
+	SyncRunRunning   = "running"
+	SyncRunSucceeded = "succeeded"
+	SyncRunFailed    = "failed"
+)
+
+// SyncDiff counts how a fetched catalog differs from the stored one.
+type SyncDiff struct {
+	Existing int `json:"existing"`
+	Fetched  int `json:"fetched"`
+	Added    int `json:"added"`
+	Missing  int `json:"missing"`
+	Changed  int `json:"changed"`
+}
+
+type SyncRun struct {
+	Id         string     `json:"id"`
+	Catalog    string     `json:"catalog"`
+	Status     string     `json:"status"`
+	Reason     string     `json:"reason,omitempty"`
+	Diff       SyncDiff   `json:"diff"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// SourceCreatedAt is when the upstream produced the data, from the
+	// NASDAQ File Creation Time trailer. Nil for sources without one.
+	SourceCreatedAt *time.Time `json:"source_created_at,omitempty"`
+}
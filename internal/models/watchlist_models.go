@@ -8,12 +8,41 @@ type Watchlist struct {
 	Id     string  `json:"id"`
 	Name string `json:"name"`
 	CreatedAt time.Time `json:"created_at"`
-	Stocks []Stock `json:"stocks"`
-	Crypto []Crypto `json:"crypto"`
+	Stocks []WatchlistStock `json:"stocks"`
+	Crypto []WatchlistCrypto `json:"crypto"`
+}
+
+// Watchlist item statuses. Deactivated instruments stay on watchlists so
+// users can see they were delisted rather than have them vanish.
+const (
+	ItemStatusActive      = "active"
+	ItemStatusDeactivated = "deactivated"
+)
+
+// ItemStatus is the badge shown for an instrument on a watchlist.
+func ItemStatus(active bool) string {
+	if active {
+		return ItemStatusActive
+	}
+	return ItemStatusDeactivated
+}
+
+type WatchlistStock struct {
+	Stock
+	Status string `json:"status"`
+}
+
+type WatchlistCrypto struct {
+	Crypto
+	Status string `json:"status"`
 }
 
 type CreateWatchlistRequest struct {
-	Name string `json:"name"`
-	Stocks []string `json:"stocks"`
-	Crypto []string `json:"crypto"`
-}
\ No newline at end of file
+	Name string `json:"name" binding:"required,min=1,max=100,watchlist_name"`
+	Stocks []string `json:"stocks" binding:"max=200,dive,required,max=64"`
+	Crypto []string `json:"crypto" binding:"max=200,dive,required,max=64"`
+}
+
+type UpdateWatchlistRequest struct {
+	Name string `json:"name" binding:"required,min=1,max=100,watchlist_name"`
+}
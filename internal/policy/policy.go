@@ -0,0 +1,55 @@
+package policy
+
+// Action is what a sync does with one detected catalog change.
+type Action string
+
+const (
+	// Apply writes the change to the catalog straight away.
+	Apply Action = "apply"
+	// Review queues the change in the pending review table.
+	Review Action = "review"
+	// Ignore drops the change.
+	Ignore Action = "ignore"
+)
+
+// Review reasons, as stored in pending_stock_review and pending_crypto_review.
+const (
+	ReasonTickerNew         = "ticker_new"
+	ReasonTickerMissing     = "ticker_missing"
+	ReasonNameChanged       = "name_changed"
+	ReasonUIDNew            = "uid_new"
+	ReasonUIDMissing        = "uid_missing"
+	ReasonTickerChanged     = "ticker_changed"
+	ReasonNameTickerChanged = "name_ticker_changed"
+)
+
+// Engine decides per reason whether a change is applied, queued or ignored.
+type Engine struct {
+	actions     map[string]Action
+	missingRuns int
+}
+
+// New builds an engine from reason -> action. Missing items are only applied
+// once they have been absent for missingRuns consecutive syncs; before that
+// they are queued, so a one-off gap in a feed never deactivates anything.
+func New(actions map[string]string, missingRuns int) *Engine {
+	e := &Engine{actions: make(map[string]Action, len(actions)), missingRuns: missingRuns}
+	for reason, action := range actions {
+		e.actions[reason] = Action(action)
+	}
+	return e
+}
+
+// Decide returns the action for a change. missedRuns is the number of
+// consecutive syncs an item has been missing, including this one, and zero
+// for every other kind of change. Unknown reasons are queued.
+func (e *Engine) Decide(reason string, missedRuns int) Action {
+	action, ok := e.actions[reason]
+	if !ok {
+		return Review
+	}
+	if action == Apply && missedRuns > 0 && missedRuns < e.missingRuns {
+		return Review
+	}
+	return action
+}
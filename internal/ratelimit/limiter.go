@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets that have refilled completely are
+// dropped; recreating them later changes nothing.
+const sweepInterval = time.Minute
+
+// Result describes the caller's bucket after a request was counted.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next token, when not allowed
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter keeps one token bucket per key, e.g. per API key or client IP.
+// Buckets are in-process, so with several replicas behind a load balancer
+// each one holds an even share of the limit.
+type Limiter struct {
+	requests int
+	period   time.Duration
+	replicas int
+	capacity float64 // this replica's share of requests
+	rate     float64 // tokens per second
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// New creates a limiter allowing requests per period across replicas. Each
+// bucket holds this replica's share of requests tokens, refilled evenly over
+// period, and never less than one token.
+func New(requests int, period time.Duration, replicas int) *Limiter {
+	replicas = max(replicas, 1)
+	capacity := math.Max(1, float64(requests)/float64(replicas))
+	return &Limiter{
+		requests:  requests,
+		period:    period,
+		replicas:  replicas,
+		capacity:  capacity,
+		rate:      capacity / period.Seconds(),
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes one token from key's bucket if available.
+func (l *Limiter) Allow(key string) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	capacity := l.capacity
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	res := Result{Limit: l.requests}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = l.duration(1 - b.tokens)
+	}
+	// Report the whole limit, as the client sees it across replicas
+	res.Remaining = min(int(b.tokens*float64(l.replicas)), l.requests)
+	res.Reset = l.duration(capacity - b.tokens)
+	return res
+}
+
+// Policy renders the limit for the RateLimit-Policy header, e.g. "60;w=60".
+func (l *Limiter) Policy() string {
+	return fmt.Sprintf("%d;w=%d", l.requests, int(l.period.Seconds()))
+}
+
+func (l *Limiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// sweep drops buckets that have been idle long enough to be full again.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.period {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"stock-talk-service/internal/models"
+	"stock-talk-service/internal/telemetry"
+)
+
+type APIKeyRepository struct {
+	db *sql.DB
+}
+
+func NewAPIKeyRepository(db *sql.DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+// CreateAPIKey stores a new key by its hash and fills in CreatedAt
+func (r *APIKeyRepository) CreateAPIKey(ctx context.Context, key *models.APIKey, keyHash string) (err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "APIKeyRepository.CreateAPIKey")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	err = r.db.QueryRowContext(ctx,
+		"INSERT INTO api_key (id, name, prefix, key_hash, tier) VALUES ($1, $2, $3, $4, $5) RETURNING created_at",
+		key.Id, key.Name, key.Prefix, keyHash, key.Tier,
+	).Scan(&key.CreatedAt)
+	return translateError(err, "api key", key.Id)
+}
+
+// GetAPIKeyByHash returns the unrevoked key with the given hash
+func (r *APIKeyRepository) GetAPIKeyByHash(ctx context.Context, keyHash string) (_ *models.APIKey, err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "APIKeyRepository.GetAPIKeyByHash")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	var k models.APIKey
+	err = r.db.QueryRowContext(ctx,
+		"SELECT id, name, prefix, tier, created_at FROM api_key WHERE key_hash = $1 AND revoked_at IS NULL",
+		keyHash,
+	).Scan(&k.Id, &k.Name, &k.Prefix, &k.Tier, &k.CreatedAt)
+	if err != nil {
+		return nil, translateError(err, "api key", k.Prefix)
+	}
+	return &k, nil
+}
+
+// ListAPIKeys returns all keys, including revoked ones
+func (r *APIKeyRepository) ListAPIKeys(ctx context.Context) (_ []models.APIKey, err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "APIKeyRepository.ListAPIKeys")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	rows, err := r.db.QueryContext(ctx, "SELECT id, name, prefix, tier, created_at, revoked_at FROM api_key ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		var (
+			k         models.APIKey
+			revokedAt sql.NullTime
+		)
+		if err := rows.Scan(&k.Id, &k.Name, &k.Prefix, &k.Tier, &k.CreatedAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		if revokedAt.Valid {
+			k.RevokedAt = &revokedAt.Time
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey marks a key revoked; revoking twice reports NotFound
+func (r *APIKeyRepository) RevokeAPIKey(ctx context.Context, id string) (err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "APIKeyRepository.RevokeAPIKey")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	res, err := r.db.ExecContext(ctx,
+		"UPDATE api_key SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL",
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res, "api key", id)
+}
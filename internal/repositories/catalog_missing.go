@@ -0,0 +1,72 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// missingStreaks tracks, inside a sync transaction, how many consecutive
+// syncs each item of a catalog has been missing.
+type missingStreaks struct {
+	catalog string
+	runs    map[string]int
+	seen    map[string]bool
+}
+
+func loadMissingStreaks(ctx context.Context, tx *sql.Tx, catalog string) (*missingStreaks, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT item_key, runs FROM catalog_missing WHERE catalog = $1", catalog)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	m := &missingStreaks{catalog: catalog, runs: make(map[string]int), seen: make(map[string]bool)}
+	for rows.Next() {
+		var (
+			key  string
+			runs int
+		)
+		if err := rows.Scan(&key, &runs); err != nil {
+			return nil, err
+		}
+		m.runs[key] = runs
+	}
+	return m, rows.Err()
+}
+
+// miss records that key is missing from this sync and returns the streak
+// length including it.
+func (m *missingStreaks) miss(ctx context.Context, tx *sql.Tx, key string, now time.Time) (int, error) {
+	runs := m.runs[key] + 1
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO catalog_missing (catalog, item_key, runs, first_missed_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (catalog, item_key) DO UPDATE SET runs = excluded.runs
+	`, m.catalog, key, runs, now)
+	if err != nil {
+		return 0, err
+	}
+	m.runs[key] = runs
+	m.seen[key] = true
+	return runs, nil
+}
+
+// resolved ends the streak for an item that was deactivated this sync.
+func (m *missingStreaks) resolved(key string) {
+	m.seen[key] = false
+}
+
+// flush drops streaks for items that are back in the feed or were
+// deactivated.
+func (m *missingStreaks) flush(ctx context.Context, tx *sql.Tx) error {
+	for key := range m.runs {
+		if m.seen[key] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM catalog_missing WHERE catalog = $1 AND item_key = $2", m.catalog, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
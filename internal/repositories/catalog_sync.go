@@ -0,0 +1,626 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"stock-talk-service/internal/models"
+	"stock-talk-service/internal/policy"
+	"stock-talk-service/internal/utils"
+	"strings"
+	"time"
+)
+
+// catalogSpec describes one asset class to syncCatalog: the table holding
+// it, the column identifying an item in the feed, and the columns the feed
+// supplies. Every catalog gets the same five-step review sync from it.
+//
+// The table needs id, active and updated_at columns besides those named
+// here, and its review table needs reason, resolved, created_at and
+// resolved_at besides the key and columns.
+type catalogSpec[T any] struct {
+	catalog      string // name in instrument_history and catalog_missing
+	table        string
+	pendingTable string
+	keyColumn    string
+	// columns are written on insert and update and queued for review, in
+	// the order values returns them.
+	columns []string
+	// compared are the columns whose change is a review item; the rest
+	// are carried along when one is applied.
+	compared []string
+	// uidColumn, when set, gets a new ULID on insert. Catalogs keyed by a
+	// value that can change need one for a stable identifier.
+	uidColumn string
+	// pairBy, when set, is the column that pairs an added key with a
+	// missing item one-to-one as a key change.
+	pairBy string
+
+	newReason, missingReason, keyChangedReason string
+	// changeReasons name each combination of compared columns that can
+	// change together.
+	changeReasons []changeReason
+
+	id     func(T) string
+	key    func(T) string
+	values func(T) []string
+	build  func(id, key string, values []string) T
+}
+
+type changeReason struct {
+	reason  string
+	columns []string
+}
+
+// changeset is the diff between a catalog table and the latest feed.
+// Unchanged items don't appear in it.
+type changeset[T any] struct {
+	changed    []itemChange[T]
+	added      []itemAddition[T]
+	missing    []T
+	keyChanges []itemChange[T] // paired from added and missing by pairBy
+}
+
+// itemChange is an active item whose columns differ from the feed. columns
+// lists the compared columns that changed; a key change has none.
+type itemChange[T any] struct {
+	old     T
+	latest  T
+	columns []string
+}
+
+// itemAddition is a key with no active row. inactiveId is set when a
+// deactivated row can be relisted.
+type itemAddition[T any] struct {
+	latest     T
+	inactiveId string
+}
+
+// syncCatalog reconciles spec's table with latest inside tx and returns how
+// many changes were applied. The caller commits tx. guard sees the diff before anything
+// is written and aborts the sync by returning an error; engine decides per
+// change whether it is applied, queued for review or dropped.
+//
+// latest is staged in a temp table and diffed with joins, so the number of
+// statements grows with the number of changes rather than the catalog size.
+func syncCatalog[T any](ctx context.Context, tx *sql.Tx, postgres bool, spec catalogSpec[T], latest []T, guard func(models.SyncDiff) error, engine *policy.Engine) (int, error) {
+	staged, err := spec.stage(ctx, tx, postgres, latest)
+	if err != nil {
+		return 0, err
+	}
+
+	changes, err := spec.loadChanges(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	diff := models.SyncDiff{
+		Fetched: staged,
+		Added:   len(changes.added) + len(changes.keyChanges),
+		Missing: len(changes.missing) + len(changes.keyChanges),
+		Changed: len(changes.changed),
+	}
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+spec.table+" WHERE active").Scan(&diff.Existing); err != nil {
+		return 0, err
+	}
+	if err := guard(diff); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	if err := spec.resolveReviews(ctx, tx, staged, now); err != nil {
+		return 0, err
+	}
+
+	streaks, err := loadMissingStreaks(ctx, tx, spec.catalog)
+	if err != nil {
+		return 0, err
+	}
+	a := catalogApplier[T]{spec: spec, tx: tx, now: now}
+	if err := a.apply(ctx, changes, engine, streaks); err != nil {
+		return 0, err
+	}
+	if err := streaks.flush(ctx, tx); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DROP TABLE "+spec.stagedTable()); err != nil {
+		return 0, err
+	}
+	return a.applied, nil
+}
+
+func (spec catalogSpec[T]) stagedTable() string {
+	return "sync_latest_" + spec.catalog
+}
+
+// stage loads latest into the staged table and returns how many distinct
+// keys it holds. Later duplicates of a key win.
+func (spec catalogSpec[T]) stage(ctx context.Context, tx *sql.Tx, postgres bool, latest []T) (int, error) {
+	byKey := make(map[string]int, len(latest))
+	rows := make([][]any, 0, len(latest))
+	for _, item := range latest {
+		row := []any{spec.key(item)}
+		for _, v := range spec.values(item) {
+			row = append(row, v)
+		}
+		if i, ok := byKey[spec.key(item)]; ok {
+			rows[i] = row
+			continue
+		}
+		byKey[spec.key(item)] = len(rows)
+		rows = append(rows, row)
+	}
+
+	defs := []string{spec.keyColumn + " TEXT PRIMARY KEY"}
+	for _, c := range spec.columns {
+		defs = append(defs, c+" TEXT NOT NULL")
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("CREATE TEMP TABLE %s (%s)", spec.stagedTable(), strings.Join(defs, ", "))); err != nil {
+		return 0, err
+	}
+	if err := stageRows(ctx, tx, postgres, spec.stagedTable(), append([]string{spec.keyColumn}, spec.columns...), rows); err != nil {
+		return 0, fmt.Errorf("staging latest %s: %w", spec.catalog, err)
+	}
+	return len(rows), nil
+}
+
+// selectColumns lists the spec's columns qualified by alias
+func (spec catalogSpec[T]) selectColumns(alias string) string {
+	cols := make([]string, len(spec.columns))
+	for i, c := range spec.columns {
+		cols[i] = alias + "." + c
+	}
+	return strings.Join(cols, ", ")
+}
+
+func (spec catalogSpec[T]) loadChanges(ctx context.Context, tx *sql.Tx) (*changeset[T], error) {
+	changes := &changeset[T]{}
+	k := spec.keyColumn
+
+	if len(spec.compared) > 0 {
+		differs := make([]string, len(spec.compared))
+		for i, c := range spec.compared {
+			differs[i] = fmt.Sprintf("COALESCE(t.%s, '') <> l.%s", c, c)
+		}
+		query := fmt.Sprintf(`
+			SELECT t.id, t.%s, %s, %s
+			FROM %s t JOIN %s l ON l.%s = t.%s
+			WHERE t.active AND (%s)
+		`, k, spec.selectColumns("t"), spec.selectColumns("l"), spec.table, spec.stagedTable(), k, k, strings.Join(differs, " OR "))
+		err := scanRows(ctx, tx, query, 2+2*len(spec.columns), func(v []string) {
+			n := len(spec.columns)
+			old := spec.build(v[0], v[1], v[2:2+n])
+			latest := spec.build(v[0], v[1], v[2+n:])
+			changes.changed = append(changes.changed, itemChange[T]{old: old, latest: latest, columns: spec.changedColumns(old, latest)})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Any row joined here is inactive; a key delisted more than once
+	// relists its most recently updated row, so reruns pick the same one.
+	// The IS NULL term keeps NULL timestamps last on every driver.
+	query := fmt.Sprintf(`
+		SELECT l.%s, %s, t.id
+		FROM %s l LEFT JOIN %s t ON t.%s = l.%s
+		WHERE NOT EXISTS (SELECT 1 FROM %s a WHERE a.%s = l.%s AND a.active)
+		ORDER BY t.updated_at IS NULL, t.updated_at DESC, t.id DESC
+	`, k, spec.selectColumns("l"), spec.stagedTable(), spec.table, k, k, spec.table, k, k)
+	seen := make(map[string]bool)
+	err := scanRows(ctx, tx, query, 2+len(spec.columns), func(v []string) {
+		if seen[v[0]] {
+			return
+		}
+		seen[v[0]] = true
+		changes.added = append(changes.added, itemAddition[T]{
+			latest:     spec.build("", v[0], v[1:1+len(spec.columns)]),
+			inactiveId: v[len(v)-1],
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query = fmt.Sprintf(`
+		SELECT t.id, t.%s, %s FROM %s t
+		WHERE t.active AND NOT EXISTS (SELECT 1 FROM %s l WHERE l.%s = t.%s)
+	`, k, spec.selectColumns("t"), spec.table, spec.stagedTable(), k, k)
+	err = scanRows(ctx, tx, query, 2+len(spec.columns), func(v []string) {
+		changes.missing = append(changes.missing, spec.build(v[0], v[1], v[2:]))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	spec.pairKeyChanges(changes)
+	return changes, nil
+}
+
+// scanRows runs query and hands each row to fn as strings, NULLs as "". Ids
+// of any type scan as their text form.
+func scanRows(ctx context.Context, tx *sql.Tx, query string, width int, fn func([]string)) error {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	values := make([]sql.NullString, width)
+	dest := make([]any, width)
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		row := make([]string, width)
+		for i, v := range values {
+			row[i] = v.String
+		}
+		fn(row)
+	}
+	return rows.Err()
+}
+
+// changedColumns lists the compared columns that differ between old and
+// latest
+func (spec catalogSpec[T]) changedColumns(old, latest T) []string {
+	oldValues, latestValues := spec.values(old), spec.values(latest)
+	var changed []string
+	for i, c := range spec.columns {
+		if slices.Contains(spec.compared, c) && oldValues[i] != latestValues[i] {
+			changed = append(changed, c)
+		}
+	}
+	return changed
+}
+
+// changeReason names a change of exactly columns
+func (spec catalogSpec[T]) changeReason(columns []string) string {
+	for _, r := range spec.changeReasons {
+		if len(r.columns) == len(columns) && !slices.ContainsFunc(columns, func(c string) bool { return !slices.Contains(r.columns, c) }) {
+			return r.reason
+		}
+	}
+	return ""
+}
+
+// pairKeyChanges moves one-to-one matches by pairBy between brand-new keys
+// and missing items out of added and missing into keyChanges. Anything
+// ambiguous is left as a separate listing and delisting.
+func (spec catalogSpec[T]) pairKeyChanges(changes *changeset[T]) {
+	if spec.pairBy == "" {
+		return
+	}
+	col := slices.Index(spec.columns, spec.pairBy)
+	pairValue := func(item T) string { return spec.values(item)[col] }
+
+	missingBy := make(map[string][]int)
+	for i, item := range changes.missing {
+		missingBy[pairValue(item)] = append(missingBy[pairValue(item)], i)
+	}
+	addedBy := make(map[string][]int)
+	for i, a := range changes.added {
+		if a.inactiveId == "" {
+			addedBy[pairValue(a.latest)] = append(addedBy[pairValue(a.latest)], i)
+		}
+	}
+
+	pairedAdded := make(map[int]bool)
+	pairedMissing := make(map[int]bool)
+	for value, added := range addedBy {
+		missing := missingBy[value]
+		if len(added) != 1 || len(missing) != 1 {
+			continue
+		}
+		changes.keyChanges = append(changes.keyChanges, itemChange[T]{
+			old:    changes.missing[missing[0]],
+			latest: changes.added[added[0]].latest,
+		})
+		pairedAdded[added[0]] = true
+		pairedMissing[missing[0]] = true
+	}
+
+	var added []itemAddition[T]
+	for i, a := range changes.added {
+		if !pairedAdded[i] {
+			added = append(added, a)
+		}
+	}
+	var missing []T
+	for i, item := range changes.missing {
+		if !pairedMissing[i] {
+			missing = append(missing, item)
+		}
+	}
+	changes.added, changes.missing = added, missing
+}
+
+// resolveReviews closes pending items the feed or the table has overtaken.
+// The queue persists across runs, so this is what keeps it current:
+//   - missing items that are back in the feed or no longer active
+//   - new items and key changes that are gone from the feed again, or whose
+//     key now has an active row
+//   - changes that reverted, meaning any of their columns matches the stored
+//     value again, or that the feed has since moved past
+func (spec catalogSpec[T]) resolveReviews(ctx context.Context, tx *sql.Tx, staged int, now time.Time) error {
+	k := spec.keyColumn
+	p := spec.pendingTable
+
+	// STEP 1: Resolve missing items that reappeared or were deactivated
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s SET resolved = TRUE, resolved_at = $1
+		WHERE reason = $2 AND resolved = FALSE AND (
+			%s IN (SELECT %s FROM %s)
+			OR NOT EXISTS (SELECT 1 FROM %s t WHERE t.%s = %s.%s AND t.active)
+		)
+	`, p, k, k, spec.stagedTable(), spec.table, k, p, k), now, spec.missingReason); err != nil {
+		return err
+	}
+
+	// STEP 2: Resolve new and renamed keys no longer in the feed or already
+	// active
+	for _, reason := range []string{spec.newReason, spec.keyChangedReason} {
+		if reason == "" {
+			continue
+		}
+		gone := "FALSE"
+		if staged > 0 {
+			gone = fmt.Sprintf("%s NOT IN (SELECT %s FROM %s)", k, k, spec.stagedTable())
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE %s SET resolved = TRUE, resolved_at = $1
+			WHERE reason = $2 AND resolved = FALSE AND (
+				%s
+				OR EXISTS (SELECT 1 FROM %s t WHERE t.%s = %s.%s AND t.active)
+			)
+		`, p, gone, spec.table, k, p, k), now, reason); err != nil {
+			return err
+		}
+	}
+
+	// STEP 3: Resolve changes that reverted or were overtaken
+	for _, r := range spec.changeReasons {
+		var stale []string
+		for _, c := range r.columns {
+			stale = append(stale, fmt.Sprintf("l.%s = t.%s", c, c), fmt.Sprintf("l.%s <> %s.%s", c, p, c))
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			UPDATE %s SET resolved = TRUE, resolved_at = $1
+			WHERE reason = $2 AND resolved = FALSE AND EXISTS (
+				SELECT 1 FROM %s t JOIN %s l ON l.%s = t.%s
+				WHERE t.%s = %s.%s AND t.active AND (%s)
+			)
+		`, p, spec.table, spec.stagedTable(), k, k, k, p, k, strings.Join(stale, " OR ")), now, r.reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// catalogApplier writes one changeset inside the sync transaction
+type catalogApplier[T any] struct {
+	spec    catalogSpec[T]
+	tx      *sql.Tx
+	now     time.Time
+	applied int
+}
+
+// apply runs STEP 4 (changes, key changes and additions) and STEP 5
+// (missing items) under engine's decisions
+func (a *catalogApplier[T]) apply(ctx context.Context, changes *changeset[T], engine *policy.Engine, streaks *missingStreaks) error {
+	spec := a.spec
+
+	for _, c := range changes.changed {
+		reason := spec.changeReason(c.columns)
+		switch engine.Decide(reason, 0) {
+		case policy.Apply:
+			if err := a.update(ctx, spec.id(c.old), spec.columns, spec.values(c.latest), false); err != nil {
+				return err
+			}
+			oldValues, latestValues := spec.values(c.old), spec.values(c.latest)
+			for i, col := range spec.columns {
+				if slices.Contains(c.columns, col) {
+					if err := recordChange(ctx, a.tx, spec.catalog, spec.id(c.old), col, oldValues[i], latestValues[i], reason, a.now); err != nil {
+						return err
+					}
+				}
+			}
+			if err := a.resolve(ctx, spec.key(c.latest), reason); err != nil {
+				return err
+			}
+			a.applied++
+		case policy.Review:
+			if err := a.queue(ctx, c.latest, reason); err != nil {
+				return err
+			}
+			if len(c.columns) > 1 {
+				if err := a.supersedeReviews(ctx, spec.key(c.latest), c.columns); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, c := range changes.keyChanges {
+		switch engine.Decide(spec.keyChangedReason, 0) {
+		case policy.Apply:
+			if err := a.update(ctx, spec.id(c.old), []string{spec.keyColumn}, []string{spec.key(c.latest)}, false); err != nil {
+				return err
+			}
+			if err := recordChange(ctx, a.tx, spec.catalog, spec.id(c.old), spec.keyColumn, spec.key(c.old), spec.key(c.latest), spec.keyChangedReason, a.now); err != nil {
+				return err
+			}
+			if err := a.resolve(ctx, spec.key(c.latest), spec.keyChangedReason); err != nil {
+				return err
+			}
+			a.applied++
+		case policy.Review:
+			if err := a.queue(ctx, c.latest, spec.keyChangedReason); err != nil {
+				return err
+			}
+		}
+	}
+
+	// key is new, or relisted after being deactivated
+	for _, add := range changes.added {
+		switch engine.Decide(spec.newReason, 0) {
+		case policy.Apply:
+			var err error
+			if add.inactiveId != "" {
+				err = a.update(ctx, add.inactiveId, spec.columns, spec.values(add.latest), true)
+				if err == nil {
+					err = recordActiveChange(ctx, a.tx, spec.catalog, add.inactiveId, true, spec.newReason, a.now)
+				}
+			} else {
+				err = a.insert(ctx, add.latest)
+			}
+			if err == nil {
+				err = a.resolve(ctx, spec.key(add.latest), spec.newReason)
+			}
+			if err != nil {
+				return err
+			}
+			a.applied++
+		case policy.Review:
+			if err := a.queue(ctx, add.latest, spec.newReason); err != nil {
+				return err
+			}
+		}
+	}
+
+	// STEP 5: Deactivate or queue items missing from the feed
+	for _, item := range changes.missing {
+		key := spec.key(item)
+		runs, err := streaks.miss(ctx, a.tx, key, a.now)
+		if err != nil {
+			return err
+		}
+		switch engine.Decide(spec.missingReason, runs) {
+		case policy.Apply:
+			if _, err := a.tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET active = FALSE, updated_at = $1 WHERE id = $2", spec.table), a.now, spec.id(item)); err != nil {
+				return err
+			}
+			if err := recordActiveChange(ctx, a.tx, spec.catalog, spec.id(item), false, spec.missingReason, a.now); err != nil {
+				return err
+			}
+			if err := a.resolve(ctx, key, spec.missingReason); err != nil {
+				return err
+			}
+			streaks.resolved(key)
+			a.applied++
+		case policy.Review:
+			if err := a.queue(ctx, item, spec.missingReason); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// update sets columns on the row id, reactivating it if asked
+func (a *catalogApplier[T]) update(ctx context.Context, id string, columns, values []string, reactivate bool) error {
+	var (
+		sets []string
+		args []any
+	)
+	for i, c := range columns {
+		args = append(args, values[i])
+		sets = append(sets, fmt.Sprintf("%s = $%d", c, len(args)))
+	}
+	if reactivate {
+		sets = append(sets, "active = TRUE")
+	}
+	args = append(args, a.now, id)
+	query := fmt.Sprintf("UPDATE %s SET %s, updated_at = $%d WHERE id = $%d", a.spec.table, strings.Join(sets, ", "), len(args)-1, len(args))
+	_, err := a.tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (a *catalogApplier[T]) insert(ctx context.Context, item T) error {
+	columns := append([]string{a.spec.keyColumn}, a.spec.columns...)
+	args := []any{a.spec.key(item)}
+	for _, v := range a.spec.values(item) {
+		args = append(args, v)
+	}
+	if a.spec.uidColumn != "" {
+		uid, err := utils.NewULID(a.now)
+		if err != nil {
+			return err
+		}
+		columns = append(columns, a.spec.uidColumn)
+		args = append(args, uid)
+	}
+	columns = append(columns, "active", "updated_at")
+	args = append(args, true, a.now)
+
+	_, err := a.tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", a.spec.table, strings.Join(columns, ", "), paramList(1, len(args))), args...)
+	return err
+}
+
+// queue adds an unresolved review item unless one with the same values is
+// already queued
+func (a *catalogApplier[T]) queue(ctx context.Context, item T, reason string) error {
+	columns := append([]string{a.spec.keyColumn}, a.spec.columns...)
+	args := []any{a.spec.key(item)}
+	for _, v := range a.spec.values(item) {
+		args = append(args, v)
+	}
+	args = append(args, reason)
+
+	conds := make([]string, len(columns))
+	for i, c := range columns {
+		conds[i] = fmt.Sprintf("%s = $%d", c, i+1)
+	}
+	var count int
+	err := a.tx.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT COUNT(*) FROM %s WHERE %s AND reason = $%d AND resolved = FALSE
+	`, a.spec.pendingTable, strings.Join(conds, " AND "), len(args)), args...).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	args = append(args, a.now)
+	_, err = a.tx.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s (%s, reason, resolved, created_at) VALUES (%s, FALSE, $%d)
+	`, a.spec.pendingTable, strings.Join(columns, ", "), paramList(1, len(args)-1), len(args)), args...)
+	return err
+}
+
+// supersedeReviews resolves the single-column change reviews for key that a
+// review of a combined change replaces
+func (a *catalogApplier[T]) supersedeReviews(ctx context.Context, key string, columns []string) error {
+	for _, c := range columns {
+		reason := a.spec.changeReason([]string{c})
+		if reason == "" {
+			continue
+		}
+		if err := a.resolve(ctx, key, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve closes key's pending reviews for reason, e.g. once the change they
+// queued has been applied
+func (a *catalogApplier[T]) resolve(ctx context.Context, key, reason string) error {
+	_, err := a.tx.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s SET resolved = TRUE, resolved_at = $1
+		WHERE %s = $2 AND reason = $3 AND resolved = FALSE
+	`, a.spec.pendingTable, a.spec.keyColumn), a.now, key, reason)
+	return err
+}
+
+// paramList returns "$from, ..., $to"
+func paramList(from, to int) string {
+	marks := make([]string, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		marks = append(marks, fmt.Sprintf("$%d", i))
+	}
+	return strings.Join(marks, ", ")
+}
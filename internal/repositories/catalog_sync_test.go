@@ -0,0 +1,488 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"stock-talk-service/internal/db"
+	"stock-talk-service/internal/models"
+	"stock-talk-service/internal/policy"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// item is a catalog entry independent of asset class. key is the stock
+// ticker or the crypto uid.
+type item struct {
+	key      string
+	name     string
+	inactive bool
+	updated  string
+}
+
+// catalogHarness runs the shared cases against one catalogSpec. Review
+// reasons are reported by kind ("new", "missing", "name", "key") so the
+// cases don't depend on each catalog's reason names.
+type catalogHarness struct {
+	name    string
+	table   string
+	pending string
+	reasons map[string]string // kind -> reason
+	seed    func(t *testing.T, d *sql.DB, items []item)
+	sync    func(ctx context.Context, d *sql.DB, latest []item, guard func(models.SyncDiff) error, engine *policy.Engine) (int, error)
+}
+
+var catalogHarnesses = []catalogHarness{
+	{
+		name:    "stock",
+		table:   "stock",
+		pending: "pending_stock_review",
+		reasons: map[string]string{
+			"new":     policy.ReasonTickerNew,
+			"missing": policy.ReasonTickerMissing,
+			"name":    policy.ReasonNameChanged,
+			"key":     policy.ReasonTickerChanged,
+		},
+		seed: func(t *testing.T, d *sql.DB, items []item) {
+			for i, it := range items {
+				mustExec(t, d, "INSERT INTO stock (ticker, name, uid, active, updated_at) VALUES ($1, $2, $3, $4, $5)",
+					it.key, it.name, fmt.Sprintf("uid-%d", i), !it.inactive, updatedAt(it))
+			}
+		},
+		sync: func(ctx context.Context, d *sql.DB, latest []item, guard func(models.SyncDiff) error, engine *policy.Engine) (int, error) {
+			stocks := make([]models.Stock, len(latest))
+			for i, it := range latest {
+				stocks[i] = models.Stock{Ticker: it.key, Name: it.name}
+			}
+			return syncInTx(ctx, d, stockCatalog, stocks, guard, engine)
+		},
+	},
+	{
+		name:    "crypto",
+		table:   "crypto",
+		pending: "pending_crypto_review",
+		reasons: map[string]string{
+			"new":     policy.ReasonUIDNew,
+			"missing": policy.ReasonUIDMissing,
+			"name":    policy.ReasonNameChanged,
+		},
+		seed: func(t *testing.T, d *sql.DB, items []item) {
+			for _, it := range items {
+				mustExec(t, d, "INSERT INTO crypto (uid, coingecko_id, ticker, name, active, updated_at) VALUES ($1, $2, $3, $4, $5, $6)",
+					it.key, strings.ToLower(it.key), strings.ToLower(it.key), it.name, !it.inactive, updatedAt(it))
+			}
+		},
+		sync: func(ctx context.Context, d *sql.DB, latest []item, guard func(models.SyncDiff) error, engine *policy.Engine) (int, error) {
+			cryptos := make([]models.Crypto, len(latest))
+			for i, it := range latest {
+				k := strings.ToLower(it.key)
+				cryptos[i] = models.Crypto{Uid: it.key, CoingeckoId: k, Ticker: k, Name: it.name}
+			}
+			return syncInTx(ctx, d, cryptoCatalog, cryptos, guard, engine)
+		},
+	},
+}
+
+func TestSyncCatalog(t *testing.T) {
+	allow := func(models.SyncDiff) error { return nil }
+
+	tests := []struct {
+		name string
+		seed []item
+		// runs are the feeds of consecutive syncs
+		runs        [][]item
+		actions     map[string]policy.Action // by kind; unset kinds are applied
+		missingRuns int
+		// rows are "key name active" for every row; pending and resolved
+		// are "kind key name" for reviews. The byCatalog variants override
+		// them where the catalogs differ.
+		rows             []string
+		pending          []string
+		resolved         []string
+		rowsByCatalog    map[string][]string
+		pendingByCatalog map[string][]string
+	}{
+		{
+			name: "new item applied",
+			seed: []item{{key: "AAA", name: "Alpha"}},
+			runs: [][]item{{{key: "AAA", name: "Alpha"}, {key: "BBB", name: "Beta"}}},
+			rows: []string{"AAA Alpha true", "BBB Beta true"},
+		},
+		{
+			name:    "new item queued once across runs",
+			seed:    []item{{key: "AAA", name: "Alpha"}},
+			runs:    [][]item{{{key: "AAA", name: "Alpha"}, {key: "BBB", name: "Beta"}}, {{key: "AAA", name: "Alpha"}, {key: "BBB", name: "Beta"}}},
+			actions: map[string]policy.Action{"new": policy.Review},
+			rows:    []string{"AAA Alpha true"},
+			pending: []string{"new BBB Beta"},
+		},
+		{
+			name:     "queued new item gone from feed",
+			seed:     []item{{key: "AAA", name: "Alpha"}},
+			runs:     [][]item{{{key: "AAA", name: "Alpha"}, {key: "BBB", name: "Beta"}}, {{key: "AAA", name: "Alpha"}}},
+			actions:  map[string]policy.Action{"new": policy.Review},
+			rows:     []string{"AAA Alpha true"},
+			resolved: []string{"new BBB Beta"},
+		},
+		{
+			name:        "missing item deactivated after streak",
+			seed:        []item{{key: "AAA", name: "Alpha"}, {key: "BBB", name: "Beta"}},
+			runs:        [][]item{{{key: "AAA", name: "Alpha"}}, {{key: "AAA", name: "Alpha"}}},
+			missingRuns: 2,
+			rows:        []string{"AAA Alpha true", "BBB Beta false"},
+			resolved:    []string{"missing BBB Beta"},
+		},
+		{
+			name:        "missing item queued before streak",
+			seed:        []item{{key: "AAA", name: "Alpha"}, {key: "BBB", name: "Beta"}},
+			runs:        [][]item{{{key: "AAA", name: "Alpha"}}},
+			missingRuns: 2,
+			rows:        []string{"AAA Alpha true", "BBB Beta true"},
+			pending:     []string{"missing BBB Beta"},
+		},
+		{
+			name:     "missing item reappears",
+			seed:     []item{{key: "AAA", name: "Alpha"}, {key: "BBB", name: "Beta"}},
+			runs:     [][]item{{{key: "AAA", name: "Alpha"}}, {{key: "AAA", name: "Alpha"}, {key: "BBB", name: "Beta"}}},
+			actions:  map[string]policy.Action{"missing": policy.Review},
+			rows:     []string{"AAA Alpha true", "BBB Beta true"},
+			resolved: []string{"missing BBB Beta"},
+		},
+		{
+			name: "name change applied",
+			seed: []item{{key: "AAA", name: "Alpha"}},
+			runs: [][]item{{{key: "AAA", name: "Alpha Corp"}}},
+			rows: []string{"AAA Alpha Corp true"},
+		},
+		{
+			name:    "name change queued once across runs",
+			seed:    []item{{key: "AAA", name: "Alpha"}},
+			runs:    [][]item{{{key: "AAA", name: "Alpha Corp"}}, {{key: "AAA", name: "Alpha Corp"}}},
+			actions: map[string]policy.Action{"name": policy.Review},
+			rows:    []string{"AAA Alpha true"},
+			pending: []string{"name AAA Alpha Corp"},
+		},
+		{
+			name:     "queued name change reverted",
+			seed:     []item{{key: "AAA", name: "Alpha"}},
+			runs:     [][]item{{{key: "AAA", name: "Alpha Corp"}}, {{key: "AAA", name: "Alpha"}}},
+			actions:  map[string]policy.Action{"name": policy.Review},
+			rows:     []string{"AAA Alpha true"},
+			resolved: []string{"name AAA Alpha Corp"},
+		},
+		{
+			name:     "queued name change overtaken",
+			seed:     []item{{key: "AAA", name: "Alpha"}},
+			runs:     [][]item{{{key: "AAA", name: "Alpha Corp"}}, {{key: "AAA", name: "Alpha Inc"}}},
+			actions:  map[string]policy.Action{"name": policy.Review},
+			rows:     []string{"AAA Alpha true"},
+			pending:  []string{"name AAA Alpha Inc"},
+			resolved: []string{"name AAA Alpha Corp"},
+		},
+		{
+			name: "ticker paired by name",
+			seed: []item{{key: "AAA", name: "Acme"}},
+			runs: [][]item{{{key: "AAB", name: "Acme"}}},
+			rowsByCatalog: map[string][]string{
+				"stock":  {"AAB Acme true"},
+				"crypto": {"AAA Acme false", "AAB Acme true"},
+			},
+		},
+		{
+			name:    "ticker paired by name queued",
+			seed:    []item{{key: "AAA", name: "Acme"}},
+			runs:    [][]item{{{key: "AAB", name: "Acme"}}},
+			actions: map[string]policy.Action{"new": policy.Review, "missing": policy.Review, "key": policy.Review},
+			rows:    []string{"AAA Acme true"},
+			pendingByCatalog: map[string][]string{
+				"stock":  {"key AAB Acme"},
+				"crypto": {"missing AAA Acme", "new AAB Acme"},
+			},
+		},
+		{
+			name:    "ambiguous pairing stays separate",
+			seed:    []item{{key: "AAA", name: "Acme"}, {key: "AAC", name: "Acme"}},
+			runs:    [][]item{{{key: "AAB", name: "Acme"}}},
+			actions: map[string]policy.Action{"new": policy.Review, "missing": policy.Review, "key": policy.Review},
+			rows:    []string{"AAA Acme true", "AAC Acme true"},
+			pending: []string{"missing AAA Acme", "missing AAC Acme", "new AAB Acme"},
+		},
+		{
+			name:    "ignored changes",
+			seed:    []item{{key: "AAA", name: "Alpha"}, {key: "BBB", name: "Beta"}},
+			runs:    [][]item{{{key: "AAA", name: "Alpha Corp"}, {key: "CCC", name: "Gamma"}}},
+			actions: map[string]policy.Action{"new": policy.Ignore, "missing": policy.Ignore, "name": policy.Ignore},
+			rows:    []string{"AAA Alpha true", "BBB Beta true"},
+		},
+		{
+			name: "mixed per-reason decisions",
+			seed: []item{{key: "AAA", name: "Alpha"}, {key: "BBB", name: "Beta"}},
+			runs: [][]item{{{key: "AAA", name: "Alpha Corp"}, {key: "CCC", name: "Gamma"}}},
+			actions: map[string]policy.Action{
+				"new":     policy.Apply,
+				"missing": policy.Review,
+				"name":    policy.Ignore,
+			},
+			rows:    []string{"AAA Alpha true", "BBB Beta true", "CCC Gamma true"},
+			pending: []string{"missing BBB Beta"},
+		},
+		{
+			name: "relist most recently updated inactive row",
+			seed: []item{
+				{key: "AAA", name: "Alpha"},
+				{key: "BBB", name: "Beta Old", inactive: true, updated: "2023-01-01 00:00:00"},
+				{key: "BBB", name: "Beta Newer", inactive: true, updated: "2024-01-01 00:00:00"},
+			},
+			runs: [][]item{{{key: "AAA", name: "Alpha"}, {key: "BBB", name: "Beta"}}},
+			rows: []string{"AAA Alpha true", "BBB Beta Old false", "BBB Beta true"},
+		},
+		{
+			// More rows than one staging batch, on the non-COPY path
+			name: "large feed staged in batches",
+			seed: []item{{key: "AAA", name: "Alpha"}},
+			runs: [][]item{generateItems(2*stageBatchRows + 1)},
+			rows: append([]string{"AAA Alpha false"}, itemRows(generateItems(2*stageBatchRows+1))...),
+		},
+	}
+
+	for _, h := range catalogHarnesses {
+		for _, tt := range tests {
+			t.Run(h.name+"/"+tt.name, func(t *testing.T) {
+				ctx := context.Background()
+				d := openTestDB(t)
+				h.seed(t, d, tt.seed)
+
+				actions := make(map[string]string)
+				for kind, reason := range h.reasons {
+					action := policy.Apply
+					if a, ok := tt.actions[kind]; ok {
+						action = a
+					}
+					actions[reason] = string(action)
+				}
+				if _, ok := h.reasons["key"]; !ok {
+					// Only the stock catalog pairs keys; crypto reports the
+					// combined ticker and name change instead
+					actions[policy.ReasonTickerChanged] = string(policy.Apply)
+					actions[policy.ReasonNameTickerChanged] = string(policy.Apply)
+				}
+				missingRuns := tt.missingRuns
+				if missingRuns == 0 {
+					missingRuns = 1
+				}
+				engine := policy.New(actions, missingRuns)
+
+				for i, latest := range tt.runs {
+					if _, err := h.sync(ctx, d, latest, allow, engine); err != nil {
+						t.Fatalf("sync %d: %v", i+1, err)
+					}
+				}
+
+				wantRows, wantPending := tt.rows, tt.pending
+				if rows, ok := tt.rowsByCatalog[h.name]; ok {
+					wantRows = rows
+				}
+				if pending, ok := tt.pendingByCatalog[h.name]; ok {
+					wantPending = pending
+				}
+				assertStrings(t, "rows", catalogRows(t, d, h), wantRows)
+				assertStrings(t, "pending", reviews(t, d, h, false), wantPending)
+				assertStrings(t, "resolved", reviews(t, d, h, true), tt.resolved)
+			})
+		}
+	}
+}
+
+func TestSyncCatalogGuardAbort(t *testing.T) {
+	for _, h := range catalogHarnesses {
+		t.Run(h.name, func(t *testing.T) {
+			ctx := context.Background()
+			d := openTestDB(t)
+			h.seed(t, d, []item{{key: "AAA", name: "Alpha"}, {key: "BBB", name: "Beta"}})
+
+			actions := make(map[string]string)
+			for _, reason := range h.reasons {
+				actions[reason] = string(policy.Review)
+			}
+			engine := policy.New(actions, 1)
+			if _, err := h.sync(ctx, d, []item{{key: "AAA", name: "Alpha"}}, func(models.SyncDiff) error { return nil }, engine); err != nil {
+				t.Fatalf("first sync: %v", err)
+			}
+
+			errTooMany := errors.New("too many changes")
+			var seen models.SyncDiff
+			guard := func(diff models.SyncDiff) error {
+				seen = diff
+				return errTooMany
+			}
+			latest := []item{{key: "AAA", name: "Alpha Corp"}, {key: "CCC", name: "Gamma"}}
+			if _, err := h.sync(ctx, d, latest, guard, engine); !errors.Is(err, errTooMany) {
+				t.Fatalf("sync error = %v, want %v", err, errTooMany)
+			}
+			want := models.SyncDiff{Fetched: 2, Existing: 2, Added: 1, Missing: 1, Changed: 1}
+			if seen != want {
+				t.Errorf("guard saw %+v, want %+v", seen, want)
+			}
+
+			// Nothing written, the earlier review kept, and the staged table
+			// gone with the rollback so the next sync can run
+			assertStrings(t, "rows", catalogRows(t, d, h), []string{"AAA Alpha true", "BBB Beta true"})
+			assertStrings(t, "pending", reviews(t, d, h, false), []string{"missing BBB Beta"})
+			if _, err := h.sync(ctx, d, []item{{key: "AAA", name: "Alpha"}, {key: "BBB", name: "Beta"}}, func(models.SyncDiff) error { return nil }, engine); err != nil {
+				t.Fatalf("sync after abort: %v", err)
+			}
+			assertStrings(t, "pending", reviews(t, d, h, false), nil)
+			assertStrings(t, "resolved", reviews(t, d, h, true), []string{"missing BBB Beta"})
+		})
+	}
+}
+
+// openTestDB creates a SQLite database with the tables that predate the
+// migrations, then migrates it.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	d, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	for _, q := range []string{
+		`CREATE TABLE stock (id INTEGER PRIMARY KEY, ticker TEXT, name TEXT, active BOOLEAN DEFAULT TRUE, updated_at TIMESTAMP)`,
+		`CREATE TABLE pending_stock_review (id INTEGER PRIMARY KEY, ticker TEXT, name TEXT, reason TEXT, resolved BOOLEAN, created_at TIMESTAMP, resolved_at TIMESTAMP)`,
+		`CREATE TABLE crypto (id INTEGER PRIMARY KEY, uid TEXT, coingecko_id TEXT, ticker TEXT, name TEXT, active BOOLEAN DEFAULT TRUE, updated_at TIMESTAMP)`,
+		`CREATE TABLE pending_crypto_review (id INTEGER PRIMARY KEY, uid TEXT, coingecko_id TEXT, ticker TEXT, name TEXT, reason TEXT, resolved BOOLEAN, created_at TIMESTAMP, resolved_at TIMESTAMP)`,
+		`CREATE TABLE watchlist (id INTEGER PRIMARY KEY, name TEXT, created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE TABLE watchlist_stock (watchlist_id INTEGER, stock_id INTEGER)`,
+		`CREATE TABLE watchlist_crypto (watchlist_id INTEGER, crypto_id INTEGER)`,
+	} {
+		mustExec(t, d, q)
+	}
+	if err := db.Migrate(context.Background(), d); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	return d
+}
+
+func mustExec(t *testing.T, d *sql.DB, query string, args ...any) {
+	t.Helper()
+	if _, err := d.Exec(query, args...); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+
+// syncInTx runs syncCatalog in its own transaction, committing on success
+func syncInTx[T any](ctx context.Context, d *sql.DB, spec catalogSpec[T], latest []T, guard func(models.SyncDiff) error, engine *policy.Engine) (int, error) {
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	applied, err := syncCatalog(ctx, tx, isPostgres(d), spec, latest, guard, engine)
+	if err != nil {
+		return 0, err
+	}
+	return applied, tx.Commit()
+}
+
+func updatedAt(it item) string {
+	if it.updated == "" {
+		return "2020-01-01 00:00:00"
+	}
+	return it.updated
+}
+
+func generateItems(n int) []item {
+	items := make([]item, n)
+	for i := range items {
+		items[i] = item{key: fmt.Sprintf("G%05d", i), name: fmt.Sprintf("Generated %d", i)}
+	}
+	return items
+}
+
+func itemRows(items []item) []string {
+	rows := make([]string, len(items))
+	for i, it := range items {
+		rows[i] = it.key + " " + it.name + " true"
+	}
+	return rows
+}
+
+func catalogRows(t *testing.T, d *sql.DB, h catalogHarness) []string {
+	t.Helper()
+	key := "ticker"
+	if h.name == "crypto" {
+		key = "uid"
+	}
+	return queryStrings(t, d, fmt.Sprintf("SELECT %s, name, active FROM %s", key, h.table), func(v []any) string {
+		return fmt.Sprintf("%s %s %t", v[0], v[1], v[2])
+	}, new(string), new(string), new(bool))
+}
+
+// reviews lists the review items that are resolved, or still pending
+func reviews(t *testing.T, d *sql.DB, h catalogHarness, resolved bool) []string {
+	t.Helper()
+	kinds := make(map[string]string)
+	for kind, reason := range h.reasons {
+		kinds[reason] = kind
+	}
+	key := "ticker"
+	if h.name == "crypto" {
+		key = "uid"
+	}
+	return queryStrings(t, d, fmt.Sprintf("SELECT reason, %s, name FROM %s WHERE resolved = %t", key, h.pending, resolved), func(v []any) string {
+		kind, ok := kinds[v[0].(string)]
+		if !ok {
+			kind = v[0].(string)
+		}
+		return fmt.Sprintf("%s %s %s", kind, v[1], v[2])
+	}, new(string), new(string), new(string))
+}
+
+// queryStrings formats each row with format and returns them sorted
+func queryStrings(t *testing.T, d *sql.DB, query string, format func([]any) string, dest ...any) []string {
+	t.Helper()
+	rows, err := d.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			t.Fatal(err)
+		}
+		values := make([]any, len(dest))
+		for i, p := range dest {
+			switch p := p.(type) {
+			case *string:
+				values[i] = *p
+			case *bool:
+				values[i] = *p
+			}
+		}
+		out = append(out, format(values))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(out)
+	return out
+}
+
+func assertStrings(t *testing.T, what string, got, want []string) {
+	t.Helper()
+	want = slices.Clone(want)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("%s:\n got  %q\n want %q", what, got, want)
+	}
+}
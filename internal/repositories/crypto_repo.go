@@ -1,9 +1,14 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
+	"stock-talk-service/internal/invalidation"
 	"stock-talk-service/internal/models"
+	"stock-talk-service/internal/policy"
+	"stock-talk-service/internal/telemetry"
 	"strings"
 	"sync"
 	"time"
@@ -13,17 +18,24 @@ type CryptoRepository struct {
 	db         *sql.DB
 	cache      map[string]models.Crypto // id -> Crypto
 	cacheMutex sync.RWMutex
+	publisher  invalidation.Publisher
 }
 
-func NewCryptoRepository(db *sql.DB) *CryptoRepository {
+// NewCryptoRepository creates the repository. publisher may be nil when
+// running a single replica.
+func NewCryptoRepository(db *sql.DB, publisher invalidation.Publisher) *CryptoRepository {
 	return &CryptoRepository{
-		db:    db,
-		cache: make(map[string]models.Crypto),
+		db:        db,
+		cache:     make(map[string]models.Crypto),
+		publisher: publisher,
 	}
 }
 
-func (r *CryptoRepository) LoadCryptoCache() error {
-	rows, err := r.db.Query("SELECT id, uid, coingecko_id, ticker, name FROM crypto")
+func (r *CryptoRepository) LoadCryptoCache(ctx context.Context) (err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "CryptoRepository.LoadCryptoCache")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	rows, err := r.db.QueryContext(ctx, "SELECT id, uid, coingecko_id, ticker, name, active FROM crypto")
 	if err != nil {
 		return err
 	}
@@ -32,7 +44,7 @@ func (r *CryptoRepository) LoadCryptoCache() error {
 	cache := make(map[string]models.Crypto)
 	for rows.Next() {
 		var c models.Crypto
-		if err := rows.Scan(&c.Id, &c.Uid, &c.CoingeckoId, &c.Ticker, &c.Name); err != nil {
+		if err := rows.Scan(&c.Id, &c.Uid, &c.CoingeckoId, &c.Ticker, &c.Name, &c.Active); err != nil {
 			return err
 		}
 		cache[c.Id] = c
@@ -44,12 +56,16 @@ func (r *CryptoRepository) LoadCryptoCache() error {
 	return rows.Err()
 }
 
-func (r *CryptoRepository) GetAllCrypto() []models.Crypto {
+// GetAllCrypto returns the cached coins, skipping deactivated ones unless
+// includeInactive is set
+func (r *CryptoRepository) GetAllCrypto(includeInactive bool) []models.Crypto {
 	r.cacheMutex.RLock()
 	defer r.cacheMutex.RUnlock()
 	cryptos := make([]models.Crypto, 0, len(r.cache))
 	for _, c := range r.cache {
-		cryptos = append(cryptos, c)
+		if c.Active || includeInactive {
+			cryptos = append(cryptos, c)
+		}
 	}
 	return cryptos
 }
@@ -61,28 +77,80 @@ func (r *CryptoRepository) GetCryptoByID(id string) (models.Crypto, bool) {
 	return c, ok
 }
 
-func (r *CryptoRepository) SaveCryptoInitialLoad(cryptos []models.Crypto) error {
-	tx, err := r.db.Begin()
+// CacheSize returns the number of cached entries
+func (r *CryptoRepository) CacheSize() int {
+	r.cacheMutex.RLock()
+	defer r.cacheMutex.RUnlock()
+	return len(r.cache)
+}
+
+// SaveCryptoInitialLoad upserts the catalog by uid. Known coins keep their
+// id so watchlists and clients holding it stay valid, and coins missing from
+// the mapping are deactivated rather than deleted.
+func (r *CryptoRepository) SaveCryptoInitialLoad(ctx context.Context, cryptos []models.Crypto) (err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "CryptoRepository.SaveCryptoInitialLoad")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec("DELETE FROM crypto")
+	existing := make(map[string]models.Crypto)
+	rows, err := tx.QueryContext(ctx, "SELECT id, uid, coingecko_id, ticker, name, active FROM crypto")
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
+	for rows.Next() {
+		var c models.Crypto
+		if err := rows.Scan(&c.Id, &c.Uid, &c.CoingeckoId, &c.Ticker, &c.Name, &c.Active); err != nil {
+			return err
+		}
+		existing[c.Uid] = c
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
 	now := time.Now()
+	latest := make(map[string]bool, len(cryptos))
+	var added []models.Crypto
+	for _, c := range cryptos {
+		latest[c.Uid] = true
+		old, ok := existing[c.Uid]
+		if !ok {
+			added = append(added, c)
+			continue
+		}
+		if old.Active && old.CoingeckoId == c.CoingeckoId && old.Ticker == c.Ticker && old.Name == c.Name {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE crypto SET coingecko_id = $1, ticker = $2, name = $3, active = TRUE, updated_at = $4 WHERE id = $5
+		`, c.CoingeckoId, c.Ticker, c.Name, now, old.Id); err != nil {
+			return err
+		}
+	}
+	for uid, old := range existing {
+		if latest[uid] || !old.Active {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE crypto SET active = FALSE, updated_at = $1 WHERE id = $2", now, old.Id); err != nil {
+			return err
+		}
+	}
+
 	batchSize := 1000
-	total := len(cryptos)
+	total := len(added)
 
 	for start := 0; start < total; start += batchSize {
 		end := start + batchSize
 		if end > total {
 			end = total
 		}
-		batch := cryptos[start:end]
+		batch := added[start:end]
 
 		var (
 			args         []interface{}
@@ -94,275 +162,84 @@ func (r *CryptoRepository) SaveCryptoInitialLoad(cryptos []models.Crypto) error
 		}
 
 		query := "INSERT INTO crypto (uid, coingecko_id, ticker, name, active, updated_at) VALUES " + strings.Join(placeholders, ",")
-		if _, err := tx.Exec(query, args...); err != nil {
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
 			return err
 		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		return err
-	}
+	return invalidation.Commit(ctx, tx, r.publisher, invalidation.CacheCrypto, r.LoadCryptoCache)
+}
 
-	return r.LoadCryptoCache()
+// cryptoCatalog syncs coins by the mapping file's uid, which never changes.
+// The CoinGecko id follows along with any applied change.
+var cryptoCatalog = catalogSpec[models.Crypto]{
+	catalog:       catalogCrypto,
+	table:         "crypto",
+	pendingTable:  "pending_crypto_review",
+	keyColumn:     "uid",
+	columns:       []string{"coingecko_id", "ticker", "name"},
+	compared:      []string{"ticker", "name"},
+	newReason:     policy.ReasonUIDNew,
+	missingReason: policy.ReasonUIDMissing,
+	changeReasons: []changeReason{
+		{reason: policy.ReasonNameChanged, columns: []string{"name"}},
+		{reason: policy.ReasonTickerChanged, columns: []string{"ticker"}},
+		{reason: policy.ReasonNameTickerChanged, columns: []string{"ticker", "name"}},
+	},
+	id:     func(c models.Crypto) string { return c.Id },
+	key:    func(c models.Crypto) string { return c.Uid },
+	values: func(c models.Crypto) []string { return []string{c.CoingeckoId, c.Ticker, c.Name} },
+	build: func(id, uid string, values []string) models.Crypto {
+		return models.Crypto{Id: id, Uid: uid, CoingeckoId: values[0], Ticker: values[1], Name: values[2]}
+	},
 }
 
-func (r *CryptoRepository) SaveCryptoWithReview(latestCryptos []models.Crypto) error {
-	tx, err := r.db.Begin()
+// SaveCryptoWithReview reconciles the stored catalog with the mapping file.
+// guard sees the diff before anything is written and aborts the save by
+// returning an error. engine decides per change whether it is written to
+// crypto, queued in pending_crypto_review or dropped.
+func (r *CryptoRepository) SaveCryptoWithReview(ctx context.Context, latestCryptos []models.Crypto, guard func(models.SyncDiff) error, engine *policy.Engine) (err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "CryptoRepository.SaveCryptoWithReview")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec("DELETE FROM pending_crypto_review")
+	applied, err := syncCatalog(ctx, tx, isPostgres(r.db), cryptoCatalog, latestCryptos, guard, engine)
 	if err != nil {
 		return err
 	}
-
-	existing := make(map[string]models.Crypto)
-	rows, err := tx.Query("SELECT id, uid, coingecko_id, ticker, name FROM crypto")
-	if err != nil {
+	if err := invalidation.Commit(ctx, tx, r.publisher, invalidation.CacheCrypto, r.LoadCryptoCache); err != nil {
 		return err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var c models.Crypto
-		if err := rows.Scan(&c.Id, &c.Uid, &c.CoingeckoId, &c.Ticker, &c.Name); err != nil {
-			return err
-		}
-		existing[c.Uid] = c
-	}
-
-	latestMap := make(map[string]models.Crypto)
-	for _, c := range latestCryptos {
-		latestMap[c.Uid] = c
-	}
+	slog.InfoContext(ctx, "crypto review changes applied", "applied", applied)
+	return nil
+}
 
-	now := time.Now()
+// CountPendingReviews returns the number of unresolved review items
+func (r *CryptoRepository) CountPendingReviews(ctx context.Context) (_ int, err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "CryptoRepository.CountPendingReviews")
+	defer func() { telemetry.EndSpan(span, err) }()
 
-	// Prepare UIDs list for queries
-	uids := make([]interface{}, 0, len(latestCryptos))
-	for _, c := range latestCryptos {
-		uids = append(uids, c.Uid)
-	}
+	var count int
+	err = r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pending_crypto_review WHERE resolved = FALSE").Scan(&count)
+	return count, err
+}
 
-	// STEP 1: Resolve previously missing UIDs that now reappear
-	if len(uids) > 0 {
-		placeholders := make([]string, len(uids))
-		for i := range uids {
-			placeholders[i] = fmt.Sprintf("$%d", i+1)
-		}
-		query := fmt.Sprintf(`
-			UPDATE pending_crypto_review
-			SET resolved = TRUE, resolved_at = $%d
-			WHERE uid IN (%s) AND reason = 'uid_missing' AND resolved = FALSE
-		`, len(uids)+1, strings.Join(placeholders, ","))
-		args := append(uids, now)
-		if _, err = tx.Exec(query, args...); err != nil {
-			return err
-		}
-	}
+// DeleteResolvedReviews removes resolved review items older than the cutoff
+func (r *CryptoRepository) DeleteResolvedReviews(ctx context.Context, before time.Time) (_ int64, err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "CryptoRepository.DeleteResolvedReviews")
+	defer func() { telemetry.EndSpan(span, err) }()
 
-	// STEP 2: Resolve 'uid_new' if UID no longer in latest list
-	if len(uids) > 0 {
-		placeholders := make([]string, len(uids))
-		for i := range uids {
-			placeholders[i] = fmt.Sprintf("$%d", i+1)
-		}
-		query := fmt.Sprintf(`
-			UPDATE pending_crypto_review
-			SET resolved = TRUE, resolved_at = $%d
-			WHERE uid NOT IN (%s) AND reason = 'uid_new' AND resolved = FALSE
-		`, len(uids)+1, strings.Join(placeholders, ","))
-		args := append(uids, now)
-		if _, err = tx.Exec(query, args...); err != nil {
-			return err
-		}
-	}
-
-	// STEP 3: Auto-resolve reverted name/ticker discrepancies
-	rows, err = tx.Query(`
-		SELECT id, uid, reason FROM pending_crypto_review
-		WHERE resolved = FALSE AND reason IN ('name_changed', 'ticker_changed', 'name_ticker_changed')
-	`)
+	res, err := r.db.ExecContext(ctx, `
+		DELETE FROM pending_crypto_review
+		WHERE resolved = TRUE AND resolved_at < $1
+	`, before)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer rows.Close()
-
-	type pendingReview struct {
-		ID     string
-		UID    string
-		Reason string
-	}
-	var pending []pendingReview
-	for rows.Next() {
-		var r pendingReview
-		if err := rows.Scan(&r.ID, &r.UID, &r.Reason); err != nil {
-			return err
-		}
-		pending = append(pending, r)
-	}
-
-	for _, r := range pending {
-		var originalName, originalTicker string
-		err := tx.QueryRow(`SELECT name, ticker FROM crypto WHERE uid = $1`, r.UID).Scan(&originalName, &originalTicker)
-		if err != nil {
-			// Crypto no longer exists — skip
-			continue
-		}
-
-		latest, ok := latestMap[r.UID]
-		if !ok {
-			continue
-		}
-
-		resolved := false
-		switch r.Reason {
-		case "name_changed":
-			if latest.Name == originalName {
-				resolved = true
-			}
-		case "ticker_changed":
-			if latest.Ticker == originalTicker {
-				resolved = true
-			}
-		case "name_ticker_changed":
-			if latest.Name == originalName || latest.Ticker == originalTicker {
-				resolved = true
-			}
-		}
-
-		if resolved {
-			if _, err := tx.Exec(`UPDATE pending_crypto_review SET resolved = TRUE, resolved_at = $1 WHERE id = $2`, now, r.ID); err != nil {
-				return err
-			}
-		}
-	}
-
-	// STEP 4: Insert/update pending_crypto_review with new discrepancies
-	for _, latest := range latestCryptos {
-		existingCrypto, exists := existing[latest.Uid]
-		if exists {
-			nameChanged := existingCrypto.Name != latest.Name
-			tickerChanged := existingCrypto.Ticker != latest.Ticker
-
-			if nameChanged && tickerChanged {
-				// Check for existing unresolved combined reason
-				var count int
-				err := tx.QueryRow(`
-					SELECT COUNT(*) FROM pending_crypto_review
-					WHERE uid = $1 AND reason = 'name_ticker_changed' AND resolved = FALSE
-				`, latest.Uid).Scan(&count)
-				if err != nil {
-					return err
-				}
-				if count == 0 {
-					_, err := tx.Exec(`
-						INSERT INTO pending_crypto_review (uid, coingecko_id, ticker, name, reason, resolved, created_at)
-						VALUES ($1, $2, $3, $4, 'name_ticker_changed', FALSE, $5)
-					`, latest.Uid, latest.CoingeckoId, latest.Ticker, latest.Name, now)
-					if err != nil {
-						return err
-					}
-				}
-
-				// Mark individual name_changed and ticker_changed as resolved to avoid duplicates
-				_, err = tx.Exec(`
-					UPDATE pending_crypto_review
-					SET resolved = TRUE, resolved_at = $1
-					WHERE uid = $2 AND reason IN ('name_changed', 'ticker_changed') AND resolved = FALSE
-				`, now, latest.Uid)
-				if err != nil {
-					return err
-				}
-
-			} else if nameChanged {
-				var count int
-				err := tx.QueryRow(`
-					SELECT COUNT(*) FROM pending_crypto_review
-					WHERE uid = $1 AND name = $2 AND reason = 'name_changed' AND resolved = FALSE
-				`, latest.Uid, latest.Name).Scan(&count)
-				if err != nil {
-					return err
-				}
-				if count == 0 {
-					_, err := tx.Exec(`
-						INSERT INTO pending_crypto_review (uid, coingecko_id, ticker, name, reason, resolved, created_at)
-						VALUES ($1, $2, $3, $4, 'name_changed', FALSE, $5)
-					`, latest.Uid, latest.CoingeckoId, latest.Ticker, latest.Name, now)
-					if err != nil {
-						return err
-					}
-				}
-
-			} else if tickerChanged {
-				var count int
-				err := tx.QueryRow(`
-					SELECT COUNT(*) FROM pending_crypto_review
-					WHERE uid = $1 AND ticker = $2 AND reason = 'ticker_changed' AND resolved = FALSE
-				`, latest.Uid, latest.Ticker).Scan(&count)
-				if err != nil {
-					return err
-				}
-				if count == 0 {
-					_, err := tx.Exec(`
-						INSERT INTO pending_crypto_review (uid, coingecko_id, ticker, name, reason, resolved, created_at)
-						VALUES ($1, $2, $3, $4, 'ticker_changed', FALSE, $5)
-					`, latest.Uid, latest.CoingeckoId, latest.Ticker, latest.Name, now)
-					if err != nil {
-						return err
-					}
-				}
-			}
-			continue
-		}
-
-		// UID not found → uid_new
-		var count int
-		err := tx.QueryRow(`
-			SELECT COUNT(*) FROM pending_crypto_review
-			WHERE uid = $1 AND coingecko_id = $2 AND ticker = $3 AND name = $4 AND reason = 'uid_new' AND resolved = FALSE
-		`, latest.Uid, latest.CoingeckoId, latest.Ticker, latest.Name).Scan(&count)
-		if err != nil {
-			return err
-		}
-		if count == 0 {
-			_, err := tx.Exec(`
-				INSERT INTO pending_crypto_review (uid, coingecko_id, ticker, name, reason, resolved, created_at)
-				VALUES ($1, $2, $3, $4, 'uid_new', FALSE, $5)
-			`, latest.Uid, latest.CoingeckoId, latest.Ticker, latest.Name, now)
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	// STEP 5: Mark missing UIDs for review
-	for oldUID, oldCrypto := range existing {
-		if _, found := latestMap[oldUID]; !found {
-			var count int
-			err := tx.QueryRow(`
-				SELECT COUNT(*) FROM pending_crypto_review
-				WHERE uid = $1 AND coingecko_id = $2 AND ticker = $3 AND name = $4 AND reason = 'uid_missing' AND resolved = FALSE
-			`, oldCrypto.Uid, oldCrypto.CoingeckoId, oldCrypto.Ticker, oldCrypto.Name).Scan(&count)
-			if err != nil {
-				return err
-			}
-			if count == 0 {
-				_, err := tx.Exec(`
-					INSERT INTO pending_crypto_review (uid, coingecko_id, ticker, name, reason, resolved, created_at)
-					VALUES ($1, $2, $3, $4, 'uid_missing', FALSE, $5)
-				`, oldCrypto.Uid, oldCrypto.CoingeckoId, oldCrypto.Ticker, oldCrypto.Name, now)
-				if err != nil {
-					return err
-				}
-			}
-		}
-	}
-
-	if err := tx.Commit(); err != nil {
-		return err
-	}
-	return r.LoadCryptoCache()
+	return res.RowsAffected()
 }
-
@@ -0,0 +1,64 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"stock-talk-service/internal/apperrors"
+
+	"github.com/lib/pq"
+)
+
+// Postgres SQLSTATE codes mapped to domain errors.
+const (
+	pqForeignKeyViolation pq.ErrorCode = "23503"
+	pqUniqueViolation     pq.ErrorCode = "23505"
+)
+
+// translateError turns driver errors the caller can act on into domain
+// errors, leaving everything else to surface as an internal error.
+func translateError(err error, resource string, id any) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperrors.NotFound(resource, id)
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case pqUniqueViolation:
+			return apperrors.Conflict(resource+" already exists", err)
+		case pqForeignKeyViolation:
+			return apperrors.Validation("referenced item does not exist", map[string]string{
+				"constraint": pqErr.Constraint,
+			})
+		}
+	}
+	return err
+}
+
+// requireAffected reports NotFound when a write matched no rows.
+func requireAffected(res sql.Result, resource string, id any) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return apperrors.NotFound(resource, id)
+	}
+	return nil
+}
+
+// requireReferenced reports an INSERT ... SELECT that matched no referenced
+// row the same way translateError reports a foreign key violation
+func requireReferenced(res sql.Result, field string, id any) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return apperrors.Validation("referenced item does not exist", map[string]any{field: id})
+	}
+	return nil
+}
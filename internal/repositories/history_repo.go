@@ -0,0 +1,87 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"stock-talk-service/internal/models"
+	"stock-talk-service/internal/telemetry"
+	"strconv"
+	"time"
+)
+
+// Catalog names used in instrument_history and catalog_missing.
+const (
+	catalogStock  = "stock"
+	catalogCrypto = "crypto"
+)
+
+type HistoryRepository struct {
+	db *sql.DB
+}
+
+func NewHistoryRepository(db *sql.DB) *HistoryRepository {
+	return &HistoryRepository{db: db}
+}
+
+// ListChanges returns an instrument's changes, oldest first
+func (r *HistoryRepository) ListChanges(ctx context.Context, catalog, instrumentId string) (_ []models.InstrumentChange, err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "HistoryRepository.ListChanges")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT field, old_value, new_value, reason, effective_at
+		FROM instrument_history
+		WHERE catalog = $1 AND instrument_id = $2
+		ORDER BY effective_at
+	`, catalog, instrumentId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []models.InstrumentChange{}
+	for rows.Next() {
+		var c models.InstrumentChange
+		if err := rows.Scan(&c.Field, &c.OldValue, &c.NewValue, &c.Reason, &c.EffectiveAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// FindStockByOldTicker returns the id of the stock that most recently gave
+// up ticker, if any
+func (r *HistoryRepository) FindStockByOldTicker(ctx context.Context, ticker string) (_ string, _ bool, err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "HistoryRepository.FindStockByOldTicker")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	var id string
+	err = r.db.QueryRowContext(ctx, `
+		SELECT instrument_id FROM instrument_history
+		WHERE catalog = $1 AND field = $2 AND UPPER(old_value) = UPPER($3)
+		ORDER BY effective_at DESC LIMIT 1
+	`, catalogStock, models.ChangeFieldTicker, ticker).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return id, true, nil
+}
+
+// recordChange adds a history row inside a sync transaction
+func recordChange(ctx context.Context, tx *sql.Tx, catalog, instrumentId, field, oldValue, newValue, reason string, at time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO instrument_history (catalog, instrument_id, field, old_value, new_value, reason, effective_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, catalog, instrumentId, field, oldValue, newValue, reason, at)
+	return err
+}
+
+// recordActiveChange records a deactivation or reactivation
+func recordActiveChange(ctx context.Context, tx *sql.Tx, catalog, instrumentId string, active bool, reason string, at time.Time) error {
+	return recordChange(ctx, tx, catalog, instrumentId, models.ChangeFieldActive,
+		strconv.FormatBool(!active), strconv.FormatBool(active), reason, at)
+}
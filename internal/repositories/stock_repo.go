@@ -1,9 +1,15 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
+	"stock-talk-service/internal/invalidation"
 	"stock-talk-service/internal/models"
+	"stock-talk-service/internal/policy"
+	"stock-talk-service/internal/telemetry"
+	"stock-talk-service/internal/utils"
 	"strings"
 	"sync"
 	"time"
@@ -12,44 +18,65 @@ import (
 type StockRepository struct {
 	db         *sql.DB
 	cache      map[string]models.Stock // id -> Stock
+	byTicker   map[string]string       // upper-case ticker -> id
 	cacheMutex sync.RWMutex
+	publisher  invalidation.Publisher
 }
 
-func NewStockRepository(db *sql.DB) *StockRepository {
+// NewStockRepository creates the repository. publisher may be nil when
+// running a single replica.
+func NewStockRepository(db *sql.DB, publisher invalidation.Publisher) *StockRepository {
 	return &StockRepository{
-		db:    db,
-		cache: make(map[string]models.Stock),
+		db:        db,
+		cache:     make(map[string]models.Stock),
+		byTicker:  make(map[string]string),
+		publisher: publisher,
 	}
 }
 
-func (r *StockRepository) LoadStockCache() error {
-	rows, err := r.db.Query("SELECT id, ticker, name FROM stock")
+func (r *StockRepository) LoadStockCache(ctx context.Context) (err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "StockRepository.LoadStockCache")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	// uid is NULL only for rows written before AssignMissingUIDs last ran
+	rows, err := r.db.QueryContext(ctx, "SELECT id, COALESCE(uid, ''), ticker, name, active FROM stock")
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
 
 	cache := make(map[string]models.Stock)
+	byTicker := make(map[string]string)
 	for rows.Next() {
 		var s models.Stock
-		if err := rows.Scan(&s.Id, &s.Ticker, &s.Name); err != nil {
+		if err := rows.Scan(&s.Id, &s.Uid, &s.Ticker, &s.Name, &s.Active); err != nil {
 			return err
 		}
 		cache[s.Id] = s
+		// A ticker reused after a delisting resolves to the active row
+		key := strings.ToUpper(s.Ticker)
+		if prev, ok := byTicker[key]; !ok || !cache[prev].Active {
+			byTicker[key] = s.Id
+		}
 	}
 
 	r.cacheMutex.Lock()
 	r.cache = cache
+	r.byTicker = byTicker
 	r.cacheMutex.Unlock()
 	return rows.Err()
 }
 
-func (r *StockRepository) GetAllStocks() []models.Stock {
+// GetAllStocks returns the cached stocks, skipping deactivated ones unless
+// includeInactive is set
+func (r *StockRepository) GetAllStocks(includeInactive bool) []models.Stock {
 	r.cacheMutex.RLock()
 	defer r.cacheMutex.RUnlock()
 	stocks := make([]models.Stock, 0, len(r.cache))
 	for _, s := range r.cache {
-		stocks = append(stocks, s)
+		if s.Active || includeInactive {
+			stocks = append(stocks, s)
+		}
 	}
 	return stocks
 }
@@ -61,239 +88,231 @@ func (r *StockRepository) GetStockById(id string) (models.Stock, bool) {
 	return s, ok
 }
 
-// Initial load: Delete all and reinsert (safe in dev or once)
-func (r *StockRepository) SaveStocksInitialLoad(stocks []models.Stock) error {
-	tx, err := r.db.Begin()
+// GetStockByTicker looks a stock up by ticker, ignoring case
+func (r *StockRepository) GetStockByTicker(ticker string) (models.Stock, bool) {
+	r.cacheMutex.RLock()
+	defer r.cacheMutex.RUnlock()
+	id, ok := r.byTicker[strings.ToUpper(ticker)]
+	if !ok {
+		return models.Stock{}, false
+	}
+	s, ok := r.cache[id]
+	return s, ok
+}
+
+// CacheSize returns the number of cached entries
+func (r *StockRepository) CacheSize() int {
+	r.cacheMutex.RLock()
+	defer r.cacheMutex.RUnlock()
+	return len(r.cache)
+}
+
+// SaveStocksInitialLoad upserts the catalog by ticker. Known tickers keep
+// their id and uid so watchlists and clients holding them stay valid, new
+// ones are inserted with a fresh uid, and stocks missing from the list are
+// deactivated rather than deleted.
+func (r *StockRepository) SaveStocksInitialLoad(ctx context.Context, stocks []models.Stock) (err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "StockRepository.SaveStocksInitialLoad")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec("DELETE FROM stock")
+	// A ticker reused after a delisting matches the active row
+	existing := make(map[string]models.Stock)
+	rows, err := tx.QueryContext(ctx, "SELECT id, ticker, name, active FROM stock")
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
+	for rows.Next() {
+		var s models.Stock
+		if err := rows.Scan(&s.Id, &s.Ticker, &s.Name, &s.Active); err != nil {
+			return err
+		}
+		if prev, ok := existing[s.Ticker]; !ok || !prev.Active {
+			existing[s.Ticker] = s
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
 	now := time.Now()
+	latest := make(map[string]bool, len(stocks))
+	var added []models.Stock
+	for _, s := range stocks {
+		latest[s.Ticker] = true
+		old, ok := existing[s.Ticker]
+		if !ok {
+			added = append(added, s)
+			continue
+		}
+		if old.Active && old.Name == s.Name {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE stock SET name = $1, active = TRUE, updated_at = $2 WHERE id = $3", s.Name, now, old.Id); err != nil {
+			return err
+		}
+	}
+	for ticker, old := range existing {
+		if latest[ticker] || !old.Active {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE stock SET active = FALSE, updated_at = $1 WHERE id = $2", now, old.Id); err != nil {
+			return err
+		}
+	}
+
 	batchSize := 1000
-	total := len(stocks)
+	total := len(added)
 
 	for start := 0; start < total; start += batchSize {
 		end := start + batchSize
 		if end > total {
 			end = total
 		}
-		batch := stocks[start:end]
+		batch := added[start:end]
 
 		var (
 			args         []interface{}
 			placeholders []string
 		)
 		for i, s := range batch {
-			placeholders = append(placeholders, fmt.Sprintf("($%d, $%d, $%d, $%d)", i*4+1, i*4+2, i*4+3, i*4+4))
-			args = append(args, s.Ticker, s.Name, true, now)
+			uid, err := utils.NewULID(now)
+			if err != nil {
+				return err
+			}
+			placeholders = append(placeholders, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", i*5+1, i*5+2, i*5+3, i*5+4, i*5+5))
+			args = append(args, uid, s.Ticker, s.Name, true, now)
 		}
 
-		query := "INSERT INTO stock (ticker, name, active, updated_at) VALUES " + strings.Join(placeholders, ",")
-		if _, err := tx.Exec(query, args...); err != nil {
+		query := "INSERT INTO stock (uid, ticker, name, active, updated_at) VALUES " + strings.Join(placeholders, ",")
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
 			return err
 		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		return err
-	}
-
-	return r.LoadStockCache()
+	return invalidation.Commit(ctx, tx, r.publisher, invalidation.CacheStock, r.LoadStockCache)
 }
 
-// SaveStocksWithReview applies manual review logic according to your spec
-func (r *StockRepository) SaveStocksWithReview(latestStocks []models.Stock) error {
-	tx, err := r.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
+// AssignMissingUIDs gives every stock without a uid a new one. It runs at
+// startup so rows from before uids existed, or written by an older replica
+// during a rollout, are covered.
+func (r *StockRepository) AssignMissingUIDs(ctx context.Context) (_ int, err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "StockRepository.AssignMissingUIDs")
+	defer func() { telemetry.EndSpan(span, err) }()
 
-	_, err = tx.Exec("DELETE FROM pending_stock_review")
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	defer tx.Rollback()
 
-	existing := make(map[string]models.Stock)
-	rows, err := tx.Query("SELECT id, ticker, name FROM stock")
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM stock WHERE uid IS NULL")
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer rows.Close()
+	var ids []string
 	for rows.Next() {
-		var s models.Stock
-		if err := rows.Scan(&s.Id, &s.Ticker, &s.Name); err != nil {
-			return err
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
 		}
-		existing[s.Ticker] = s
+		ids = append(ids, id)
 	}
-
-	latestMap := make(map[string]models.Stock)
-	tickers := make([]interface{}, 0, len(latestStocks))
-	for _, s := range latestStocks {
-		latestMap[s.Ticker] = s
-		tickers = append(tickers, s.Ticker)
+	if err := rows.Err(); err != nil {
+		return 0, err
 	}
 
 	now := time.Now()
-
-	// STEP 1: Resolve reappeared tickers previously marked as missing
-	if len(tickers) > 0 {
-		placeholders := make([]string, len(tickers))
-		for i := range tickers {
-			placeholders[i] = fmt.Sprintf("$%d", i+1)
+	for _, id := range ids {
+		uid, err := utils.NewULID(now)
+		if err != nil {
+			return 0, err
 		}
-		query := fmt.Sprintf(`
-			UPDATE pending_stock_review
-			SET resolved = TRUE, resolved_at = $%d
-			WHERE ticker IN (%s) AND reason = 'ticker_missing' AND resolved = FALSE
-		`, len(tickers)+1, strings.Join(placeholders, ","))
-		args := append(tickers, now)
-		if _, err := tx.Exec(query, args...); err != nil {
-			return err
+		if _, err := tx.ExecContext(ctx, "UPDATE stock SET uid = $1 WHERE id = $2", uid, id); err != nil {
+			return 0, err
 		}
 	}
+	return len(ids), tx.Commit()
+}
 
-	// STEP 2: Resolve 'ticker_new' for tickers no longer in latest
-	if len(tickers) > 0 {
-		placeholders := make([]string, len(tickers))
-		for i := range tickers {
-			placeholders[i] = fmt.Sprintf("$%d", i+1)
-		}
-		query := fmt.Sprintf(`
-			UPDATE pending_stock_review
-			SET resolved = TRUE, resolved_at = $%d
-			WHERE ticker NOT IN (%s) AND reason = 'ticker_new' AND resolved = FALSE
-		`, len(tickers)+1, strings.Join(placeholders, ","))
-		args := append(tickers, now)
-		if _, err := tx.Exec(query, args...); err != nil {
-			return err
-		}
-	}
+// stockCatalog syncs stocks by ticker. A ticker that disappears while a new
+// one appears under the same name is a ticker change.
+var stockCatalog = catalogSpec[models.Stock]{
+	catalog:          catalogStock,
+	table:            "stock",
+	pendingTable:     "pending_stock_review",
+	keyColumn:        "ticker",
+	columns:          []string{"name"},
+	compared:         []string{"name"},
+	uidColumn:        "uid",
+	pairBy:           "name",
+	newReason:        policy.ReasonTickerNew,
+	missingReason:    policy.ReasonTickerMissing,
+	keyChangedReason: policy.ReasonTickerChanged,
+	changeReasons: []changeReason{
+		{reason: policy.ReasonNameChanged, columns: []string{"name"}},
+	},
+	id:     func(s models.Stock) string { return s.Id },
+	key:    func(s models.Stock) string { return s.Ticker },
+	values: func(s models.Stock) []string { return []string{s.Name} },
+	build: func(id, ticker string, values []string) models.Stock {
+		return models.Stock{Id: id, Ticker: ticker, Name: values[0]}
+	},
+}
 
-	// STEP 3: Auto-resolve name_changed if name reverted
-	rows, err = tx.Query(`
-		SELECT id, ticker, name FROM pending_stock_review
-		WHERE reason = 'name_changed' AND resolved = FALSE
-	`)
+// SaveStocksWithReview applies manual review logic according to your spec.
+// guard sees the diff against the stored catalog before anything is written
+// and aborts the save by returning an error. engine decides per change
+// whether it is written to stock, queued in pending_stock_review or dropped.
+func (r *StockRepository) SaveStocksWithReview(ctx context.Context, latestStocks []models.Stock, guard func(models.SyncDiff) error, engine *policy.Engine) (err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "StockRepository.SaveStocksWithReview")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	type pendingReview struct {
-		ID     string
-		Ticker string
-		Name   string
-	}
-	var pending []pendingReview
-	for rows.Next() {
-		var r pendingReview
-		if err := rows.Scan(&r.ID, &r.Ticker, &r.Name); err != nil {
-			return err
-		}
-		pending = append(pending, r)
+	applied, err := syncCatalog(ctx, tx, isPostgres(r.db), stockCatalog, latestStocks, guard, engine)
+	if err != nil {
+		return err
 	}
-
-	for _, r := range pending {
-		original, ok := existing[r.Ticker]
-		if !ok {
-			continue
-		}
-		latest, ok := latestMap[r.Ticker]
-		if !ok {
-			continue
-		}
-		if latest.Name == original.Name {
-			if _, err := tx.Exec(`
-				UPDATE pending_stock_review
-				SET resolved = TRUE, resolved_at = $1
-				WHERE id = $2
-			`, now, r.ID); err != nil {
-				return err
-			}
-		}
+	if err := invalidation.Commit(ctx, tx, r.publisher, invalidation.CacheStock, r.LoadStockCache); err != nil {
+		return err
 	}
+	slog.InfoContext(ctx, "stock review changes applied", "applied", applied)
+	return nil
+}
 
-	// STEP 4: Insert new review items for discrepancies
-	for _, latest := range latestStocks {
-		existingStock, exists := existing[latest.Ticker]
-
-		if exists {
-			if latest.Name != existingStock.Name {
-				var count int
-				err := tx.QueryRow(`
-					SELECT COUNT(*) FROM pending_stock_review
-					WHERE ticker = $1 AND name = $2 AND reason = 'name_changed' AND resolved = FALSE
-				`, latest.Ticker, latest.Name).Scan(&count)
-				if err != nil {
-					return err
-				}
-				if count == 0 {
-					_, err := tx.Exec(`
-						INSERT INTO pending_stock_review (ticker, name, reason, resolved, created_at)
-						VALUES ($1, $2, 'name_changed', FALSE, $3)
-					`, latest.Ticker, latest.Name, now)
-					if err != nil {
-						return err
-					}
-				}
-			}
-			continue
-		}
+// CountPendingReviews returns the number of unresolved review items
+func (r *StockRepository) CountPendingReviews(ctx context.Context) (_ int, err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "StockRepository.CountPendingReviews")
+	defer func() { telemetry.EndSpan(span, err) }()
 
-		// ticker is new → 'ticker_new'
-		var count int
-		err := tx.QueryRow(`
-			SELECT COUNT(*) FROM pending_stock_review
-			WHERE ticker = $1 AND name = $2 AND reason = 'ticker_new' AND resolved = FALSE
-		`, latest.Ticker, latest.Name).Scan(&count)
-		if err != nil {
-			return err
-		}
-		if count == 0 {
-			_, err := tx.Exec(`
-				INSERT INTO pending_stock_review (ticker, name, reason, resolved, created_at)
-				VALUES ($1, $2, 'ticker_new', FALSE, $3)
-			`, latest.Ticker, latest.Name, now)
-			if err != nil {
-				return err
-			}
-		}
-	}
+	var count int
+	err = r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pending_stock_review WHERE resolved = FALSE").Scan(&count)
+	return count, err
+}
 
-	// STEP 5: Flag missing tickers
-	for oldTicker, oldStock := range existing {
-		if _, found := latestMap[oldTicker]; !found {
-			var count int
-			err := tx.QueryRow(`
-				SELECT COUNT(*) FROM pending_stock_review
-				WHERE ticker = $1 AND name = $2 AND reason = 'ticker_missing' AND resolved = FALSE
-			`, oldTicker, oldStock.Name).Scan(&count)
-			if err != nil {
-				return err
-			}
-			if count == 0 {
-				_, err := tx.Exec(`
-					INSERT INTO pending_stock_review (ticker, name, reason, resolved, created_at)
-					VALUES ($1, $2, 'ticker_missing', FALSE, $3)
-				`, oldTicker, oldStock.Name, now)
-				if err != nil {
-					return err
-				}
-			}
-		}
-	}
+// DeleteResolvedReviews removes resolved review items older than the cutoff
+func (r *StockRepository) DeleteResolvedReviews(ctx context.Context, before time.Time) (_ int64, err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "StockRepository.DeleteResolvedReviews")
+	defer func() { telemetry.EndSpan(span, err) }()
 
-	if err := tx.Commit(); err != nil {
-		return err
+	res, err := r.db.ExecContext(ctx, `
+		DELETE FROM pending_stock_review
+		WHERE resolved = TRUE AND resolved_at < $1
+	`, before)
+	if err != nil {
+		return 0, err
 	}
-	return r.LoadStockCache()
+	return res.RowsAffected()
 }
-
@@ -0,0 +1,77 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"stock-talk-service/internal/models"
+	"stock-talk-service/internal/telemetry"
+)
+
+type SyncRunRepository struct {
+	db *sql.DB
+}
+
+func NewSyncRunRepository(db *sql.DB) *SyncRunRepository {
+	return &SyncRunRepository{db: db}
+}
+
+// StartSyncRun records a run as running
+func (r *SyncRunRepository) StartSyncRun(ctx context.Context, run *models.SyncRun) (err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "SyncRunRepository.StartSyncRun")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	_, err = r.db.ExecContext(ctx,
+		"INSERT INTO sync_run (id, catalog, status, started_at) VALUES ($1, $2, $3, $4)",
+		run.Id, run.Catalog, run.Status, run.StartedAt,
+	)
+	return err
+}
+
+// FinishSyncRun stores the outcome, reason and diff counts of a run
+func (r *SyncRunRepository) FinishSyncRun(ctx context.Context, run *models.SyncRun) (err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "SyncRunRepository.FinishSyncRun")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE sync_run
+		SET status = $1, reason = $2, existing_count = $3, fetched_count = $4,
+			added_count = $5, missing_count = $6, changed_count = $7, finished_at = $8,
+			source_created_at = $9
+		WHERE id = $10
+	`, run.Status, run.Reason, run.Diff.Existing, run.Diff.Fetched,
+		run.Diff.Added, run.Diff.Missing, run.Diff.Changed, run.FinishedAt,
+		run.SourceCreatedAt, run.Id)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res, "sync run", run.Id)
+}
+
+// LatestSyncRun returns the most recent run for a catalog
+func (r *SyncRunRepository) LatestSyncRun(ctx context.Context, catalog string) (_ *models.SyncRun, err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "SyncRunRepository.LatestSyncRun")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	var (
+		run                         models.SyncRun
+		finishedAt, sourceCreatedAt sql.NullTime
+	)
+	err = r.db.QueryRowContext(ctx, `
+		SELECT id, catalog, status, reason, existing_count, fetched_count,
+			added_count, missing_count, changed_count, started_at, finished_at,
+			source_created_at
+		FROM sync_run WHERE catalog = $1
+		ORDER BY started_at DESC LIMIT 1
+	`, catalog).Scan(&run.Id, &run.Catalog, &run.Status, &run.Reason, &run.Diff.Existing, &run.Diff.Fetched,
+		&run.Diff.Added, &run.Diff.Missing, &run.Diff.Changed, &run.StartedAt, &finishedAt, &sourceCreatedAt)
+	if err != nil {
+		return nil, translateError(err, "sync run", catalog)
+	}
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+	if sourceCreatedAt.Valid {
+		run.SourceCreatedAt = &sourceCreatedAt.Time
+	}
+	return &run, nil
+}
@@ -0,0 +1,62 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// stageBatchRows bounds the multi-row INSERTs used where COPY isn't
+// available, keeping each statement well under the driver's parameter limit.
+const stageBatchRows = 500
+
+func isPostgres(db *sql.DB) bool {
+	_, ok := db.Driver().(*pq.Driver)
+	return ok
+}
+
+// stageRows loads rows into a temp table the caller created inside tx, so a
+// sync can diff against the catalog with joins instead of one parameter per
+// item. Postgres gets a single COPY; other drivers get batched INSERTs.
+func stageRows(ctx context.Context, tx *sql.Tx, postgres bool, table string, columns []string, rows [][]any) error {
+	if postgres {
+		stmt, err := tx.PrepareContext(ctx, pq.CopyIn(table, columns...))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, row := range rows {
+			if _, err := stmt.ExecContext(ctx, row...); err != nil {
+				return err
+			}
+		}
+		_, err = stmt.ExecContext(ctx)
+		return err
+	}
+
+	for start := 0; start < len(rows); start += stageBatchRows {
+		end := min(start+stageBatchRows, len(rows))
+
+		var (
+			args         []any
+			placeholders []string
+		)
+		for _, row := range rows[start:end] {
+			marks := make([]string, len(row))
+			for i, v := range row {
+				args = append(args, v)
+				marks[i] = fmt.Sprintf("$%d", len(args))
+			}
+			placeholders = append(placeholders, "("+strings.Join(marks, ", ")+")")
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", table, strings.Join(columns, ", "), strings.Join(placeholders, ","))
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
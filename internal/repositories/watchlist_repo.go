@@ -1,8 +1,10 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
 	"stock-talk-service/internal/models"
+	"stock-talk-service/internal/telemetry"
 )
 
 type WatchlistRepository struct {
@@ -14,18 +16,27 @@ func NewWatchlistRepository(db *sql.DB) *WatchlistRepository {
 }
 
 // Get a watchlist by ID
-func (r *WatchlistRepository) GetWatchlistByID(id int) (*models.Watchlist, error) {
+func (r *WatchlistRepository) GetWatchlistByID(ctx context.Context, id int) (_ *models.Watchlist, err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "WatchlistRepository.GetWatchlistByID")
+	defer func() { telemetry.EndSpan(span, err) }()
+
 	var w models.Watchlist
-	err := r.db.QueryRow("SELECT id, name, created_at FROM watchlist WHERE id = $1", id).Scan(&w.Id, &w.Name, &w.CreatedAt)
+	err = r.db.QueryRowContext(ctx, "SELECT id, name, created_at FROM watchlist WHERE id = $1", id).Scan(&w.Id, &w.Name, &w.CreatedAt)
 	if err != nil {
+		return nil, translateError(err, "watchlist", id)
+	}
+	if err := r.loadWatchlistItems(ctx, &w); err != nil {
 		return nil, err
 	}
 	return &w, nil
 }
 
 // Get all watchlists
-func (r *WatchlistRepository) GetAllWatchlists() ([]models.Watchlist, error) {
-	rows, err := r.db.Query("SELECT id, name, created_at FROM watchlist")
+func (r *WatchlistRepository) GetAllWatchlists(ctx context.Context) (_ []models.Watchlist, err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "WatchlistRepository.GetAllWatchlists")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	rows, err := r.db.QueryContext(ctx, "SELECT id, name, created_at FROM watchlist")
 	if err != nil {
 		return nil, err
 	}
@@ -37,45 +48,51 @@ func (r *WatchlistRepository) GetAllWatchlists() ([]models.Watchlist, error) {
 		if err := rows.Scan(&w.Id, &w.Name, &w.CreatedAt); err != nil {
 			return nil, err
 		}
-		stocks, err := r.GetWatchlistStocksById(w.Id)
-		if err != nil {
+		watchlists = append(watchlists, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range watchlists {
+		if err := r.loadWatchlistItems(ctx, &watchlists[i]); err != nil {
 			return nil, err
 		}
-
-		w.Stocks = stocks
-
-		watchlists = append(watchlists, w)
 	}
 	return watchlists, nil
 }
 
 // Create a new watchlist (transactional)
-func (r *WatchlistRepository) CreateWatchlist(w *models.Watchlist, swi *[]string, cwi *[]string) error {
-	tx, err := r.db.Begin()
+func (r *WatchlistRepository) CreateWatchlist(ctx context.Context, w *models.Watchlist, swi *[]string, cwi *[]string) (err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "WatchlistRepository.CreateWatchlist")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	err = tx.QueryRow(
+	err = tx.QueryRowContext(ctx,
 		"INSERT INTO watchlist (name) VALUES ($1) RETURNING id, name, created_at",
 		w.Name,
 	).Scan(&w.Id, &w.Name, &w.CreatedAt)
 	if err != nil {
-		return err
+		return translateError(err, "watchlist", w.Name)
 	}
 
 	// Insert each watchlist item (stock), linking to the new watchlist ID
 	for _, stockId := range *swi {
-		if err := r.AddStockToWatchlist(tx, stockId, w.Id); err != nil {
-			return err
+		if err := r.AddStockToWatchlist(ctx, tx, stockId, w.Id); err != nil {
+			return translateError(err, "watchlist stock", stockId)
 		}
 	}
 
 	// Insert each watchlist item (crypto), linking to the new watchlist ID
 	for _, cryptoId := range *cwi {
-		if err := r.AddCryptoToWatchlist(tx, cryptoId, w.Id); err != nil {
-			return err
+		if err := r.AddCryptoToWatchlist(ctx, tx, cryptoId, w.Id); err != nil {
+			return translateError(err, "watchlist crypto", cryptoId)
 		}
 	}
 
@@ -83,36 +100,55 @@ func (r *WatchlistRepository) CreateWatchlist(w *models.Watchlist, swi *[]string
 }
 
 // AddStockToWatchlist inserts a watchlist item using the provided transaction
-func (r *WatchlistRepository) AddStockToWatchlist(tx *sql.Tx, stockId string, watchlistId string) error {
-    _, err := tx.Exec(
-        "INSERT INTO watchlist_stock (watchlist_id, stock_id) VALUES ($1, $2)",
-        watchlistId, stockId,
-    )
-    return err
+func (r *WatchlistRepository) AddStockToWatchlist(ctx context.Context, tx *sql.Tx, stockId string, watchlistId string) (err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "WatchlistRepository.AddStockToWatchlist")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	// Items are joined back by uid, which survives catalog reloads
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO watchlist_stock (watchlist_id, stock_id, stock_uid) SELECT $1, id, uid FROM stock WHERE id = $2",
+		watchlistId, stockId,
+	)
+	if err != nil {
+		return err
+	}
+	return requireReferenced(res, "stock_id", stockId)
 }
 
 // AddStockToWatchlist inserts a watchlist item using the provided transaction
-func (r *WatchlistRepository) AddCryptoToWatchlist(tx *sql.Tx, cryptoId string, watchlistId string) error {
-    _, err := tx.Exec(
-        "INSERT INTO watchlist_crypto (watchlist_id, crypto_id) VALUES ($1, $2)",
-        watchlistId, cryptoId,
-    )
-    return err
+func (r *WatchlistRepository) AddCryptoToWatchlist(ctx context.Context, tx *sql.Tx, cryptoId string, watchlistId string) (err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "WatchlistRepository.AddCryptoToWatchlist")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO watchlist_crypto (watchlist_id, crypto_id, crypto_uid) SELECT $1, id, uid FROM crypto WHERE id = $2",
+		watchlistId, cryptoId,
+	)
+	if err != nil {
+		return err
+	}
+	return requireReferenced(res, "crypto_id", cryptoId)
 }
 
 // Update a watchlist (transactional)
-func (r *WatchlistRepository) UpdateWatchlist(w *models.Watchlist) error {
-	tx, err := r.db.Begin()
+func (r *WatchlistRepository) UpdateWatchlist(ctx context.Context, w *models.Watchlist) (err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "WatchlistRepository.UpdateWatchlist")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec(
+	res, err := tx.ExecContext(ctx,
 		"UPDATE watchlist SET name = $1 WHERE id = $2",
 		w.Name, w.Id,
 	)
 	if err != nil {
+		return translateError(err, "watchlist", w.Id)
+	}
+	if err = requireAffected(res, "watchlist", w.Id); err != nil {
 		return err
 	}
 
@@ -120,24 +156,76 @@ func (r *WatchlistRepository) UpdateWatchlist(w *models.Watchlist) error {
 }
 
 // Delete a watchlist (transactional)
-func (r *WatchlistRepository) DeleteWatchlist(id int64) error {
-	tx, err := r.db.Begin()
+func (r *WatchlistRepository) DeleteWatchlist(ctx context.Context, id int64) (err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "WatchlistRepository.DeleteWatchlist")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec("DELETE FROM watchlist WHERE id = $1", id)
+	res, err := tx.ExecContext(ctx, "DELETE FROM watchlist WHERE id = $1", id)
 	if err != nil {
 		return err
 	}
+	if err = requireAffected(res, "watchlist", id); err != nil {
+		return err
+	}
 
 	return tx.Commit()
 }
 
-func (r *WatchlistRepository) GetWatchlistStocksById(watchlistId string) ([]models.Stock, error) {
-	rows, err := r.db.Query(
-		"SELECT s.id, s.ticker, s.name FROM watchlist_stock ws JOIN stock s ON ws.stock_id = s.id WHERE ws.watchlist_id = $1",
+// loadWatchlistItems fills in the stocks and crypto of w, including
+// deactivated ones, each with its status badge
+func (r *WatchlistRepository) loadWatchlistItems(ctx context.Context, w *models.Watchlist) (err error) {
+	if w.Stocks, err = r.GetWatchlistStocksById(ctx, w.Id); err != nil {
+		return err
+	}
+	w.Crypto, err = r.GetWatchlistCryptoById(ctx, w.Id)
+	return err
+}
+
+func (r *WatchlistRepository) GetWatchlistStocksById(ctx context.Context, watchlistId string) (_ []models.WatchlistStock, err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "WatchlistRepository.GetWatchlistStocksById")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	// Items written by a replica that predates uids, or for a row that had
+	// no uid yet, carry no uid until the startup backfill; match those by id
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT s.id, COALESCE(s.uid, ''), s.ticker, s.name, s.active
+		FROM watchlist_stock ws
+		JOIN stock s ON s.uid = ws.stock_uid OR (ws.stock_uid IS NULL AND s.id = ws.stock_id)
+		WHERE ws.watchlist_id = $1`,
+		watchlistId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stocks []models.WatchlistStock
+	for rows.Next() {
+		var item models.WatchlistStock
+		if err := rows.Scan(&item.Id, &item.Uid, &item.Ticker, &item.Name, &item.Active); err != nil {
+			return nil, err
+		}
+		item.Status = models.ItemStatus(item.Active)
+		stocks = append(stocks, item)
+	}
+	return stocks, rows.Err()
+}
+
+func (r *WatchlistRepository) GetWatchlistCryptoById(ctx context.Context, watchlistId string) (_ []models.WatchlistCrypto, err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "WatchlistRepository.GetWatchlistCryptoById")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT c.id, COALESCE(c.uid, ''), c.coingecko_id, c.ticker, c.name, c.active
+		FROM watchlist_crypto wc
+		JOIN crypto c ON c.uid = wc.crypto_uid OR (wc.crypto_uid IS NULL AND c.id = wc.crypto_id)
+		WHERE wc.watchlist_id = $1`,
 		watchlistId,
 	)
 	if err != nil {
@@ -145,14 +233,54 @@ func (r *WatchlistRepository) GetWatchlistStocksById(watchlistId string) ([]mode
 	}
 	defer rows.Close()
 
-	var stocks []models.Stock
+	var crypto []models.WatchlistCrypto
 	for rows.Next() {
-		var stock models.Stock
-		if err := rows.Scan(&stock.Id, &stock.Ticker, &stock.Name); err != nil {
+		var item models.WatchlistCrypto
+		if err := rows.Scan(&item.Id, &item.Uid, &item.CoingeckoId, &item.Ticker, &item.Name, &item.Active); err != nil {
 			return nil, err
 		}
-		stocks = append(stocks, stock)
+		item.Status = models.ItemStatus(item.Active)
+		crypto = append(crypto, item)
 	}
-	return stocks, nil
+	return crypto, rows.Err()
 }
 
+// BackfillItemUIDs fills in the instrument uid of watchlist items added
+// before items were keyed by uid, matching on the stored row id. It must run
+// after StockRepository.AssignMissingUIDs. Items whose row no longer exists
+// can't be matched and are counted in unmatched.
+func (r *WatchlistRepository) BackfillItemUIDs(ctx context.Context) (filled, unmatched int64, err error) {
+	ctx, span := telemetry.StartDBSpan(ctx, "WatchlistRepository.BackfillItemUIDs")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	for _, q := range []string{
+		"UPDATE watchlist_stock SET stock_uid = (SELECT s.uid FROM stock s WHERE s.id = watchlist_stock.stock_id) WHERE stock_uid IS NULL",
+		"UPDATE watchlist_crypto SET crypto_uid = (SELECT c.uid FROM crypto c WHERE c.id = watchlist_crypto.crypto_id) WHERE crypto_uid IS NULL",
+	} {
+		res, err := tx.ExecContext(ctx, q)
+		if err != nil {
+			return 0, 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, 0, err
+		}
+		filled += n
+	}
+
+	if err := tx.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM watchlist_stock WHERE stock_uid IS NULL)
+			+ (SELECT COUNT(*) FROM watchlist_crypto WHERE crypto_uid IS NULL)
+	`).Scan(&unmatched); err != nil {
+		return 0, 0, err
+	}
+	// Rows set to NULL again for want of a match aren't filled
+	filled -= unmatched
+	return filled, unmatched, tx.Commit()
+}
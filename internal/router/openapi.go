@@ -0,0 +1,71 @@
+package router
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// spec is the hand-maintained OpenAPI 3 document. Update it alongside any
+// route change; New refuses to start when the two disagree.
+//
+//go:embed openapi.json
+var spec []byte
+
+func serveSpec(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", spec)
+}
+
+// checkDocumented compares the registered routes with the paths and
+// operations in spec, in both directions.
+func checkDocumented(routes gin.RoutesInfo) error {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return fmt.Errorf("parsing openapi.json: %w", err)
+	}
+
+	documented := make(map[string]bool)
+	for path, item := range doc.Paths {
+		for method := range item {
+			switch method {
+			case "get", "put", "post", "delete", "patch", "head", "options":
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+
+	var problems []string
+	for _, route := range routes {
+		key := route.Method + " " + openAPIPath(route.Path)
+		if !documented[key] {
+			problems = append(problems, key+" is not documented")
+		}
+		delete(documented, key)
+	}
+	for key := range documented {
+		problems = append(problems, key+" is documented but not registered")
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("openapi.json is out of date: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// openAPIPath converts gin's /watchlists/:id to OpenAPI's /watchlists/{id}.
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") || strings.HasPrefix(s, "*") {
+			segments[i] = "{" + s[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,85 @@
+package router
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"stock-talk-service/internal/config"
+	"stock-talk-service/internal/handlers"
+	"stock-talk-service/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestRoutesMatchSpec runs the startup check on the registered routes, and
+// on copies with a route added and one removed, which it must report.
+func TestRoutesMatchSpec(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	r, err := newEngine(Handlers{
+		Health:    handlers.NewHealthGinHandler(nil),
+		Crypto:    handlers.NewCryptoGinHandler(nil),
+		Stock:     handlers.NewStockGinHandler(nil),
+		Watchlist: handlers.NewWatchlistGinHandler(nil),
+	}, Options{
+		Server:       config.ServerConfig{AllowedOrigins: []string{"*"}},
+		RateLimiters: map[string]*ratelimit.Limiter{config.TierAnonymous: ratelimit.New(1000, time.Minute, 1)},
+	})
+	if err != nil {
+		t.Fatalf("building router: %v", err)
+	}
+	routes := r.Routes()
+	removed := routes[len(routes)-1]
+
+	tests := []struct {
+		name   string
+		routes gin.RoutesInfo
+		want   string
+	}{
+		{
+			name:   "registered",
+			routes: routes,
+		},
+		{
+			name:   "undocumented route",
+			routes: append(slices.Clone(routes), gin.RouteInfo{Method: "GET", Path: "/api/v1/undocumented/:id"}),
+			want:   "GET /api/v1/undocumented/{id} is not documented",
+		},
+		{
+			name:   "unregistered path",
+			routes: routes[:len(routes)-1],
+			want:   removed.Method + " " + openAPIPath(removed.Path) + " is documented but not registered",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDocumented(tt.routes)
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("checkDocumented: %v", err)
+			case tt.want != "" && err == nil:
+				t.Errorf("checkDocumented returned nil, want %q", tt.want)
+			case tt.want != "" && !strings.Contains(err.Error(), tt.want):
+				t.Errorf("checkDocumented: %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAPIPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/healthz", "/healthz"},
+		{"/api/v1/watchlists/:id", "/api/v1/watchlists/{id}"},
+		{"/api/v1/stocks/:ticker/history", "/api/v1/stocks/{ticker}/history"},
+		{"/files/*path", "/files/{path}"},
+	}
+	for _, tt := range tests {
+		if got := openAPIPath(tt.path); got != tt.want {
+			t.Errorf("openAPIPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
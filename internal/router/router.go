@@ -0,0 +1,142 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"stock-talk-service/internal/config"
+	"stock-talk-service/internal/handlers"
+	"stock-talk-service/internal/metrics"
+	"stock-talk-service/internal/middleware"
+	"stock-talk-service/internal/ratelimit"
+	"stock-talk-service/internal/telemetry"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+)
+
+// APIPrefix is the mount point of the versioned public API. Probes and
+// metrics stay at the root where infrastructure expects them.
+const APIPrefix = "/api/v1"
+
+type Handlers struct {
+	Health    *handlers.HealthGinHandler
+	Crypto    *handlers.CryptoGinHandler
+	Stock     *handlers.StockGinHandler
+	Watchlist *handlers.WatchlistHandler
+}
+
+// Options configures CORS, proxies and body limits from Server, and API key
+// authentication and rate limiting on the versioned API. RateLimiters is
+// keyed by tier and must include config.TierAnonymous.
+type Options struct {
+	Server         config.ServerConfig
+	APIKeys        middleware.Authenticator
+	APIKeyRequired bool
+	RateLimiters   map[string]*ratelimit.Limiter
+}
+
+// New builds the gin engine with the standard middleware stack and every
+// route. It fails if a route is missing from the OpenAPI document or the
+// document lists a route that isn't registered.
+func New(h Handlers, opts Options) (*gin.Engine, error) {
+	if opts.RateLimiters[config.TierAnonymous] == nil {
+		return nil, fmt.Errorf("no rate limit configured for tier %q", config.TierAnonymous)
+	}
+
+	r, err := newEngine(h, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkDocumented(r.Routes()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// newEngine registers the middleware and routes without checking them
+// against the OpenAPI document.
+func newEngine(h Handlers, opts Options) (*gin.Engine, error) {
+	r := gin.New()
+	// nil trusts no proxy, so ClientIP is the peer address
+	if err := r.SetTrustedProxies(opts.Server.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	r.Use(
+		otelgin.Middleware(telemetry.ServiceName, otelgin.WithFilter(func(req *http.Request) bool {
+			// Probes and scrapes would otherwise dominate the trace volume
+			switch req.URL.Path {
+			case "/healthz", "/readyz", "/metrics":
+				return false
+			}
+			return true
+		})),
+		middleware.RequestID(),
+		middleware.Logger(),
+		middleware.Recovery(),
+		metrics.Middleware(),
+		middleware.Errors(),
+		middleware.BodyLimit(opts.Server.MaxBodyBytes),
+	)
+	r.Use(cors.New(corsConfig(opts.Server.AllowedOrigins)))
+	r.NoRoute(middleware.NoRoute)
+
+	r.GET("/healthz", h.Health.Liveness)
+	r.GET("/readyz", h.Health.Readiness)
+	r.GET("/metrics", metrics.Handler())
+
+	v1 := r.Group(APIPrefix,
+		middleware.APIKeyAuth(opts.APIKeys, opts.APIKeyRequired, opts.RateLimiters[config.TierAnonymous]),
+		middleware.RateLimit(opts.RateLimiters),
+	)
+	v1.GET("/openapi.json", serveSpec)
+
+	crypto := v1.Group("/crypto")
+	crypto.GET("", h.Crypto.GetAllCrypto)
+	crypto.GET("/:id", h.Crypto.GetCryptoByID)
+	crypto.GET("/:id/history-meta", h.Crypto.GetCryptoHistoryMeta)
+	crypto.GET("/prices", h.Crypto.GetCryptoPriceQuery)
+	crypto.GET("/history", h.Crypto.GetCryptoHistoryQuery)
+	crypto.GET("/ohlc", h.Crypto.GetCryptoHistoryOHLCQuery)
+	crypto.POST("/prices", h.Crypto.GetCryptoPrice)
+	crypto.POST("/history", h.Crypto.GetCryptoHistory)
+	crypto.POST("/ohlc", h.Crypto.GetCryptoHistoryOHLC)
+
+	stocks := v1.Group("/stocks")
+	stocks.GET("", h.Stock.GetAllStocks)
+	stocks.GET("/:ticker", h.Stock.GetStockByTicker)
+	stocks.GET("/:ticker/history", h.Stock.GetStockHistory)
+
+	watchlists := v1.Group("/watchlists")
+	watchlists.GET("", h.Watchlist.GetAllWatchlists)
+	watchlists.POST("", h.Watchlist.CreateWatchlist)
+	watchlists.GET("/:id", h.Watchlist.GetWatchlistByID)
+	watchlists.PUT("/:id", h.Watchlist.UpdateWatchlist)
+	watchlists.DELETE("/:id", h.Watchlist.DeleteWatchlist)
+
+	return r, nil
+}
+
+// corsConfig allows the configured origins. A "*" entry allows any origin,
+// which browsers only permit without credentials.
+func corsConfig(origins []string) cors.Config {
+	cfg := cors.Config{
+		AllowMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowHeaders: []string{"Origin", "Content-Type", "Accept", "If-None-Match", "If-Modified-Since", "Authorization", middleware.APIKeyHeader},
+		ExposeHeaders: []string{
+			"Content-Length", "Location", "ETag", "Last-Modified", "Retry-After",
+			"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy",
+		},
+		AllowCredentials: true,
+		MaxAge:           12 * time.Hour,
+	}
+	if slices.Contains(origins, "*") {
+		cfg.AllowAllOrigins = true
+		cfg.AllowCredentials = false
+	} else {
+		cfg.AllowOrigins = origins
+	}
+	return cfg
+}
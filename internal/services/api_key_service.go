@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"stock-talk-service/internal/apperrors"
+	"stock-talk-service/internal/models"
+	"stock-talk-service/internal/repositories"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// apiKeyPrefix marks our keys so they are easy to spot in leaks.
+	apiKeyPrefix = "stk_"
+
+	// apiKeyCacheTTL bounds how long a revoked key keeps working on other
+	// replicas.
+	apiKeyCacheTTL = time.Minute
+
+	// apiKeyNegativeTTL is how long an unknown key is remembered, so a client
+	// retrying a bad key doesn't reach the database on every request.
+	apiKeyNegativeTTL = 10 * time.Second
+
+	// apiKeyCacheMax caps the lookup cache so random keys can't grow it
+	// without bound. A full cache drops expired entries; if none have
+	// expired, new lookups are not cached.
+	apiKeyCacheMax = 10000
+)
+
+type cachedAPIKey struct {
+	key     *models.APIKey // nil for unknown or revoked keys
+	expires time.Time
+}
+
+type APIKeyService struct {
+	apiKeyRepo *repositories.APIKeyRepository
+	tiers      map[string]bool
+
+	mu    sync.Mutex
+	cache map[string]cachedAPIKey // key hash -> lookup result
+}
+
+// NewAPIKeyService creates the service. tiers lists the tier names keys may
+// be issued with.
+func NewAPIKeyService(apiKeyRepo *repositories.APIKeyRepository, tiers []string) *APIKeyService {
+	allowed := make(map[string]bool, len(tiers))
+	for _, t := range tiers {
+		allowed[t] = true
+	}
+	return &APIKeyService{
+		apiKeyRepo: apiKeyRepo,
+		tiers:      allowed,
+		cache:      make(map[string]cachedAPIKey),
+	}
+}
+
+// IssueAPIKey creates a key and returns it in plain text. The plain key is
+// not stored and cannot be recovered later.
+func (s *APIKeyService) IssueAPIKey(ctx context.Context, name, tier string) (string, *models.APIKey, error) {
+	if strings.TrimSpace(name) == "" {
+		return "", nil, apperrors.Validation("name is required", nil)
+	}
+	if !s.tiers[tier] {
+		return "", nil, apperrors.Validation(fmt.Sprintf("unknown tier %q", tier), map[string][]string{"tiers": s.tierNames()})
+	}
+
+	id, err := randomHex(6)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", nil, err
+	}
+
+	plain := apiKeyPrefix + id + "_" + secret
+	key := &models.APIKey{
+		Id:     id,
+		Name:   name,
+		Prefix: apiKeyPrefix + id,
+		Tier:   tier,
+	}
+	if err := s.apiKeyRepo.CreateAPIKey(ctx, key, hashAPIKey(plain)); err != nil {
+		return "", nil, err
+	}
+	return plain, key, nil
+}
+
+// Verified reports whether plain is a valid key that can be resolved from
+// the in-memory cache, without touching the database.
+func (s *APIKeyService) Verified(plain string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cached, ok := s.cache[hashAPIKey(plain)]
+	return ok && cached.key != nil && time.Now().Before(cached.expires)
+}
+
+// Authenticate resolves a plain key to its record, or returns Unauthorized.
+func (s *APIKeyService) Authenticate(ctx context.Context, plain string) (*models.APIKey, error) {
+	if !strings.HasPrefix(plain, apiKeyPrefix) {
+		return nil, apperrors.Unauthorized("invalid API key")
+	}
+	hash := hashAPIKey(plain)
+
+	s.mu.Lock()
+	cached, ok := s.cache[hash]
+	s.mu.Unlock()
+	if !ok || time.Now().After(cached.expires) {
+		key, err := s.apiKeyRepo.GetAPIKeyByHash(ctx, hash)
+		if err != nil && !apperrors.Is(err, apperrors.CodeNotFound) {
+			return nil, err
+		}
+		ttl := apiKeyCacheTTL
+		if key == nil {
+			ttl = apiKeyNegativeTTL
+		}
+		cached = cachedAPIKey{key: key, expires: time.Now().Add(ttl)}
+		s.remember(hash, cached)
+	}
+
+	if cached.key == nil {
+		return nil, apperrors.Unauthorized("invalid API key")
+	}
+	return cached.key, nil
+}
+
+// remember caches a lookup, making room by dropping expired entries.
+func (s *APIKeyService) remember(hash string, cached cachedAPIKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.cache[hash]; !ok && len(s.cache) >= apiKeyCacheMax {
+		now := time.Now()
+		for h, c := range s.cache {
+			if now.After(c.expires) {
+				delete(s.cache, h)
+			}
+		}
+		if len(s.cache) >= apiKeyCacheMax {
+			return
+		}
+	}
+	s.cache[hash] = cached
+}
+
+// ListAPIKeys returns all issued keys without their secrets
+func (s *APIKeyService) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	return s.apiKeyRepo.ListAPIKeys(ctx)
+}
+
+// RevokeAPIKey revokes a key by ID. Other replicas stop accepting it within
+// apiKeyCacheTTL.
+func (s *APIKeyService) RevokeAPIKey(ctx context.Context, id string) error {
+	if err := s.apiKeyRepo.RevokeAPIKey(ctx, id); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.cache = make(map[string]cachedAPIKey)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *APIKeyService) tierNames() []string {
+	names := make([]string, 0, len(s.tiers))
+	for t := range s.tiers {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func hashAPIKey(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
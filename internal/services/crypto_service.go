@@ -1,33 +1,50 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"stock-talk-service/internal/apperrors"
 	"stock-talk-service/internal/config"
+	"stock-talk-service/internal/metrics"
 	"stock-talk-service/internal/models"
+	"stock-talk-service/internal/policy"
 	"stock-talk-service/internal/repositories"
+	"stock-talk-service/internal/telemetry"
 	"stock-talk-service/internal/validation"
+	"strconv"
 	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type CryptoService struct {
 	cryptoRepo *repositories.CryptoRepository
+	history    *repositories.HistoryRepository
 	cfg        *config.Config
+	httpClient *http.Client
+	sync       syncRecorder
 }
 
-func NewCryptoService(cryptoRepo *repositories.CryptoRepository, cfg *config.Config) *CryptoService {
+func NewCryptoService(cryptoRepo *repositories.CryptoRepository, history *repositories.HistoryRepository, syncRuns *repositories.SyncRunRepository, engine *policy.Engine, cfg *config.Config) *CryptoService {
 	return &CryptoService{
 		cryptoRepo: cryptoRepo,
+		history:    history,
 		cfg:        cfg,
+		sync:       syncRecorder{runs: syncRuns, guards: cfg.SyncGuards, policy: engine},
+		httpClient: metrics.NewUpstreamClient(metrics.UpstreamCoinGecko),
 	}
 }
 
-// GetAllCrypto returns all crypto from the cache
-func (s *CryptoService) GetAllCrypto() []models.Crypto {
-	return s.cryptoRepo.GetAllCrypto()
+// GetAllCrypto returns crypto from the cache, active only unless
+// includeInactive is set
+func (s *CryptoService) GetAllCrypto(includeInactive bool) []models.Crypto {
+	return s.cryptoRepo.GetAllCrypto(includeInactive)
 }
 
 // GetCryptoByID returns a crypto by coingeckoId from cache
@@ -35,46 +52,105 @@ func (s *CryptoService) GetCryptoByID(id string) (models.Crypto, bool) {
 	return s.cryptoRepo.GetCryptoByID(id)
 }
 
+// GetCryptoHistoryMeta returns a crypto's ticker, name and active changes,
+// oldest first
+func (s *CryptoService) GetCryptoHistoryMeta(ctx context.Context, id string) (*models.CryptoHistoryMeta, error) {
+	crypto, ok := s.cryptoRepo.GetCryptoByID(id)
+	if !ok {
+		return nil, apperrors.NotFound("crypto", id)
+	}
+	changes, err := s.history.ListChanges(ctx, CatalogCrypto, crypto.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &models.CryptoHistoryMeta{Crypto: crypto, Changes: changes}, nil
+}
+
 // SaveCryptoInitialLoad saves all cryptos (initial load)
-func (s *CryptoService) SaveCryptoInitialLoad(cryptos []models.Crypto) error {
-	return s.cryptoRepo.SaveCryptoInitialLoad(cryptos)
+func (s *CryptoService) SaveCryptoInitialLoad(ctx context.Context, cryptos []models.Crypto) error {
+	return s.cryptoRepo.SaveCryptoInitialLoad(ctx, cryptos)
 }
 
 // SaveCryptoWithReview saves cryptos with review logic for updates
-func (s *CryptoService) SaveCryptoWithReview(cryptos []models.Crypto) error {
-	return s.cryptoRepo.SaveCryptoWithReview(cryptos)
+func (s *CryptoService) SaveCryptoWithReview(ctx context.Context, cryptos []models.Crypto, guard func(models.SyncDiff) error) error {
+	return s.cryptoRepo.SaveCryptoWithReview(ctx, cryptos, guard, s.sync.policy)
+}
+
+// CacheSize returns the number of cached cryptos
+func (s *CryptoService) CacheSize() int {
+	return s.cryptoRepo.CacheSize()
 }
 
 // ReloadCryptoCache reloads cache from DB
-func (s *CryptoService) ReloadCryptoCache() error {
-	return s.cryptoRepo.LoadCryptoCache()
+func (s *CryptoService) ReloadCryptoCache(ctx context.Context) error {
+	return s.cryptoRepo.LoadCryptoCache(ctx)
+}
+
+// RefreshValidationCache re-fetches the CoinGecko coin and currency lists
+func (s *CryptoService) RefreshValidationCache(ctx context.Context) error {
+	return validation.RefreshCaches(ctx, s.cfg)
 }
 
-func (s *CryptoService) GetCryptoPrice(coinIDs, vsCurrencies []string) (*models.CryptoPriceResponse, error) {
-	result, err := validation.ValidateAndRaise(s.cfg, coinIDs, vsCurrencies)
+// CountPendingReviews returns the number of unresolved review items
+func (s *CryptoService) CountPendingReviews(ctx context.Context) (int, error) {
+	return s.cryptoRepo.CountPendingReviews(ctx)
+}
+
+// CleanupResolvedReviews deletes resolved review items older than the cutoff
+func (s *CryptoService) CleanupResolvedReviews(ctx context.Context, before time.Time) (int64, error) {
+	return s.cryptoRepo.DeleteResolvedReviews(ctx, before)
+}
+
+// getCoinGecko issues a traced GET against the CoinGecko API and decodes the
+// JSON response into out. operation names the span without the coin ID.
+func (s *CryptoService) getCoinGecko(ctx context.Context, operation, path string, query url.Values, out interface{}, attrs ...attribute.KeyValue) (err error) {
+	ctx, span := telemetry.StartSpan(ctx, "CoinGecko "+operation, attrs...)
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.cfg.CoingeckoBaseUrl+path, nil)
 	if err != nil {
-		return &models.CryptoPriceResponse{
-			Prices:              map[string]interface{}{},
-			InvalidCoinIDs:      result.InvalidCoinIDs,
-			InvalidVsCurrencies: result.InvalidVsCurrencies,
-		}, nil
+		return err
 	}
+	req.URL.RawQuery = query.Encode()
 
-	url := fmt.Sprintf("%s/simple/price", s.cfg.CoingeckoBaseUrl)
-	req, _ := http.NewRequest("GET", url, nil)
-	q := req.URL.Query()
-	q.Add("ids", strings.Join(result.ValidCoinIDs, ","))
-	q.Add("vs_currencies", strings.Join(result.ValidVsCurrencies, ","))
-	req.URL.RawQuery = q.Encode()
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return apperrors.Upstream(metrics.UpstreamCoinGecko, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return apperrors.RateLimited("CoinGecko rate limit reached", time.Duration(retryAfter)*time.Second)
+	case resp.StatusCode == http.StatusNotFound:
+		return apperrors.NotFound("CoinGecko resource", path)
+	case resp.StatusCode >= http.StatusBadRequest:
+		return apperrors.Upstream(metrics.UpstreamCoinGecko, fmt.Errorf("GET %s: status %d", path, resp.StatusCode))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return apperrors.Upstream(metrics.UpstreamCoinGecko, fmt.Errorf("decoding %s: %w", path, err))
+	}
+	return nil
+}
 
-	resp, err := http.DefaultClient.Do(req)
+func (s *CryptoService) GetCryptoPrice(ctx context.Context, coinIDs, vsCurrencies []string) (*models.CryptoPriceResponse, error) {
+	ctx, span := telemetry.StartSpan(ctx, "CryptoService.GetCryptoPrice", attribute.Int("crypto.coin_count", len(coinIDs)))
+	defer span.End()
+
+	result, err := validation.ValidateAndRaise(ctx, s.cfg, coinIDs, vsCurrencies)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+
+	q := url.Values{}
+	q.Add("ids", strings.Join(result.ValidCoinIDs, ","))
+	q.Add("vs_currencies", strings.Join(result.ValidVsCurrencies, ","))
+	q.Add("include_last_updated_at", "true")
 
 	var data map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+	if err := s.getCoinGecko(ctx, "simple/price", "/simple/price", q, &data); err != nil {
 		return nil, err
 	}
 
@@ -92,41 +168,31 @@ func (s *CryptoService) GetCryptoPrice(coinIDs, vsCurrencies []string) (*models.
 	}, nil
 }
 
-func (s *CryptoService) GetCryptoHistory(coinIDs []string, vsCurrency, days, interval string) (*models.CryptoHistoryResponse, error) {
-	result, err := validation.ValidateAndRaise(s.cfg, coinIDs, []string{vsCurrency})
+func (s *CryptoService) GetCryptoHistory(ctx context.Context, coinIDs []string, vsCurrency, days, interval string) (*models.CryptoHistoryResponse, error) {
+	ctx, span := telemetry.StartSpan(ctx, "CryptoService.GetCryptoHistory",
+		attribute.Int("crypto.coin_count", len(coinIDs)),
+		attribute.String("crypto.days", days),
+	)
+	defer span.End()
+
+	result, err := validation.ValidateAndRaise(ctx, s.cfg, coinIDs, []string{vsCurrency})
 	if err != nil {
-		return &models.CryptoHistoryResponse{
-			Data:                map[string]models.CryptoHistoryData{},
-			InvalidCoinIDs:      result.InvalidCoinIDs,
-			InvalidVsCurrencies: result.InvalidVsCurrencies,
-		}, nil
+		return nil, err
 	}
 
 	historyData := make(map[string]models.CryptoHistoryData)
 	for _, coinID := range result.ValidCoinIDs {
-		url := fmt.Sprintf("%s/coins/%s/market_chart", s.cfg.CoingeckoBaseUrl, coinID)
-		req, _ := http.NewRequest("GET", url, nil)
-		q := req.URL.Query()
+		q := url.Values{}
 		q.Add("vs_currency", result.ValidVsCurrencies[0])
 		q.Add("days", days)
 		if interval != "" {
 			q.Add("interval", interval)
 		}
-		req.URL.RawQuery = q.Encode()
-
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			historyData[coinID] = models.CryptoHistoryData{
-				CoinID: coinID, VsCurrency: vsCurrency, Days: days, Error: err.Error(),
-			}
-			continue
-		}
-		defer resp.Body.Close()
 
 		var data map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		if err := s.getCoinGecko(ctx, "coins/market_chart", "/coins/"+coinID+"/market_chart", q, &data, attribute.String("crypto.coin_id", coinID)); err != nil {
 			historyData[coinID] = models.CryptoHistoryData{
-				CoinID: coinID, VsCurrency: vsCurrency, Days: days, Error: err.Error(),
+				CoinID: coinID, VsCurrency: vsCurrency, Days: days, Error: apperrors.From(err).Message,
 			}
 			continue
 		}
@@ -148,41 +214,31 @@ func (s *CryptoService) GetCryptoHistory(coinIDs []string, vsCurrency, days, int
 	}, nil
 }
 
-func (s *CryptoService) GetCryptoHistoryOHLC(coinIDs []string, vsCurrency, days, interval string) (*models.CryptoHistoryOHLCResponse, error) {
-	result, err := validation.ValidateAndRaise(s.cfg, coinIDs, []string{vsCurrency})
+func (s *CryptoService) GetCryptoHistoryOHLC(ctx context.Context, coinIDs []string, vsCurrency, days, interval string) (*models.CryptoHistoryOHLCResponse, error) {
+	ctx, span := telemetry.StartSpan(ctx, "CryptoService.GetCryptoHistoryOHLC",
+		attribute.Int("crypto.coin_count", len(coinIDs)),
+		attribute.String("crypto.days", days),
+	)
+	defer span.End()
+
+	result, err := validation.ValidateAndRaise(ctx, s.cfg, coinIDs, []string{vsCurrency})
 	if err != nil {
-		return &models.CryptoHistoryOHLCResponse{
-			Data:                map[string]models.CryptoHistoryOHLCData{},
-			InvalidCoinIDs:      result.InvalidCoinIDs,
-			InvalidVsCurrencies: result.InvalidVsCurrencies,
-		}, nil
+		return nil, err
 	}
 
 	ohlcData := make(map[string]models.CryptoHistoryOHLCData)
 	for _, coinID := range result.ValidCoinIDs {
-		url := fmt.Sprintf("%s/coins/%s/ohlc", s.cfg.CoingeckoBaseUrl, coinID)
-		req, _ := http.NewRequest("GET", url, nil)
-		q := req.URL.Query()
+		q := url.Values{}
 		q.Add("vs_currency", result.ValidVsCurrencies[0])
 		q.Add("days", days)
 		if interval != "" {
 			q.Add("interval", interval)
 		}
-		req.URL.RawQuery = q.Encode()
-
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			ohlcData[coinID] = models.CryptoHistoryOHLCData{
-				CoinID: coinID, VsCurrency: vsCurrency, Days: days, Error: err.Error(),
-			}
-			continue
-		}
-		defer resp.Body.Close()
 
 		var ohlc interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&ohlc); err != nil {
+		if err := s.getCoinGecko(ctx, "coins/ohlc", "/coins/"+coinID+"/ohlc", q, &ohlc, attribute.String("crypto.coin_id", coinID)); err != nil {
 			ohlcData[coinID] = models.CryptoHistoryOHLCData{
-				CoinID: coinID, VsCurrency: vsCurrency, Days: days, Error: err.Error(),
+				CoinID: coinID, VsCurrency: vsCurrency, Days: days, Error: apperrors.From(err).Message,
 			}
 			continue
 		}
@@ -203,10 +259,10 @@ func (s *CryptoService) GetCryptoHistoryOHLC(coinIDs []string, vsCurrency, days,
 }
 
 // FetchAllCrypto fetches cryptos from JSON file.
-func (s *CryptoService) FetchAllCrypto() ([]models.Crypto, error) {
-	file, err := os.Open("../../data/coin_mapping.json") // Adjust path as needed
+func (s *CryptoService) FetchAllCrypto(ctx context.Context) ([]models.Crypto, error) {
+	file, err := os.Open(s.cfg.CoinMappingFile)
 	if err != nil {
-		return nil, fmt.Errorf("error opening coin_mapping.json: %w", err)
+		return nil, fmt.Errorf("error opening coin mapping: %w", err)
 	}
 	defer file.Close()
 
@@ -222,26 +278,28 @@ func (s *CryptoService) FetchAllCrypto() ([]models.Crypto, error) {
 		crypto = append(crypto, cj.ToCrypto())
 	}
 
-	log.Printf("Crypto fetched: %d", len(crypto))
+	slog.InfoContext(ctx, "crypto fetched", "count", len(crypto))
 	return crypto, nil
 }
 
 // Wrapper to fetch and save initial load
-func (s *CryptoService) InitializeCrypto() error {
-	cryptos, err := s.FetchAllCrypto()
+func (s *CryptoService) InitializeCrypto(ctx context.Context) error {
+	cryptos, err := s.FetchAllCrypto(ctx)
 	if err != nil {
 		return err
 	}
-	return s.SaveCryptoInitialLoad(cryptos)
+	return s.SaveCryptoInitialLoad(ctx, cryptos)
 }
 
-// Wrapper to fetch and save with review
-func (s *CryptoService) FetchAndUpdateAllCrypto() error {
-	cryptos, err := s.FetchAllCrypto()
-	if err != nil {
-		return err
-	}
-	return s.SaveCryptoWithReview(cryptos)
+// Wrapper to fetch and save with review, recorded as a sync run
+func (s *CryptoService) FetchAndUpdateAllCrypto(ctx context.Context) error {
+	return s.sync.record(ctx, CatalogCrypto, func(ctx context.Context, run *models.SyncRun) error {
+		cryptos, err := s.FetchAllCrypto(ctx)
+		if err != nil {
+			return err
+		}
+		return s.SaveCryptoWithReview(ctx, cryptos, s.sync.guard(run))
+	})
 }
 
 
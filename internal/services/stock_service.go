@@ -1,101 +1,207 @@
 package services
 
 import (
-	"io"
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"stock-talk-service/internal/apperrors"
+	"stock-talk-service/internal/config"
 	"stock-talk-service/internal/ftp_client"
+	"stock-talk-service/internal/metrics"
 	"stock-talk-service/internal/models"
+	"stock-talk-service/internal/policy"
 	"stock-talk-service/internal/repositories"
+	"stock-talk-service/internal/telemetry"
 	"stock-talk-service/internal/utils"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// symbolDirectory is where NASDAQ Trader publishes the symbol files.
+const symbolDirectory = "/SymbolDirectory/"
+
 type StockService struct {
-	ftpClient *ftp_client.FTPClient
+	ftpClient ftp_client.Retriever
 	stockRepo *repositories.StockRepository
+	history   *repositories.HistoryRepository
+	sync      syncRecorder
 }
 
-func NewStockService(ftpClient *ftp_client.FTPClient, stockRepo *repositories.StockRepository) *StockService {
-	return &StockService{ftpClient: ftpClient, stockRepo: stockRepo}
+func NewStockService(ftpClient ftp_client.Retriever, stockRepo *repositories.StockRepository, history *repositories.HistoryRepository, syncRuns *repositories.SyncRunRepository, guards config.SyncGuards, engine *policy.Engine) *StockService {
+	return &StockService{
+		ftpClient: ftpClient,
+		stockRepo: stockRepo,
+		history:   history,
+		sync:      syncRecorder{runs: syncRuns, guards: guards, policy: engine},
+	}
 }
 
-func (s *StockService) GetAllStocks() []models.Stock {
-	return s.stockRepo.GetAllStocks()
+func (s *StockService) GetAllStocks(includeInactive bool) []models.Stock {
+	return s.stockRepo.GetAllStocks(includeInactive)
 }
 
 func (s *StockService) GetStockById(id string) (models.Stock, bool) {
 	return s.stockRepo.GetStockById(id)
 }
 
-func (s *StockService) SaveStocksInitialLoad(stocks []models.Stock) error {
-	return s.stockRepo.SaveStocksInitialLoad(stocks)
+func (s *StockService) GetStockByTicker(ticker string) (models.Stock, bool) {
+	return s.stockRepo.GetStockByTicker(ticker)
+}
+
+// ResolveStock looks a stock up by ticker, falling back to the stock that
+// last gave the ticker up when none currently holds it. Callers compare the
+// returned ticker with the one asked for to spot a rename.
+func (s *StockService) ResolveStock(ctx context.Context, ticker string) (models.Stock, error) {
+	if stock, ok := s.stockRepo.GetStockByTicker(ticker); ok {
+		return stock, nil
+	}
+	id, ok, err := s.history.FindStockByOldTicker(ctx, ticker)
+	if err != nil {
+		return models.Stock{}, err
+	}
+	if ok {
+		if stock, ok := s.stockRepo.GetStockById(id); ok {
+			return stock, nil
+		}
+	}
+	return models.Stock{}, apperrors.NotFound("stock", ticker)
+}
+
+// GetStockHistory returns a stock's ticker, name and active changes, oldest
+// first. Old tickers resolve like ResolveStock.
+func (s *StockService) GetStockHistory(ctx context.Context, ticker string) (*models.StockHistory, error) {
+	stock, err := s.ResolveStock(ctx, ticker)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := s.history.ListChanges(ctx, CatalogStock, stock.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &models.StockHistory{Stock: stock, Changes: changes}, nil
+}
+
+func (s *StockService) SaveStocksInitialLoad(ctx context.Context, stocks []models.Stock) error {
+	return s.stockRepo.SaveStocksInitialLoad(ctx, stocks)
+}
+
+func (s *StockService) SaveStocksWithReview(ctx context.Context, stocks []models.Stock, guard func(models.SyncDiff) error) error {
+	return s.stockRepo.SaveStocksWithReview(ctx, stocks, guard, s.sync.policy)
+}
+
+// CacheSize returns the number of cached stocks
+func (s *StockService) CacheSize() int {
+	return s.stockRepo.CacheSize()
 }
 
-func (s *StockService) SaveStocksWithReview(stocks []models.Stock) error {
-	return s.stockRepo.SaveStocksWithReview(stocks)
+// ReloadStockCache reloads cache from DB
+func (s *StockService) ReloadStockCache(ctx context.Context) error {
+	return s.stockRepo.LoadStockCache(ctx)
 }
 
-// Fetch from FTP and parse both NASDAQ and other listed, return combined slice
-func (s *StockService) FetchAllStocks() ([]models.Stock, error) {
+// CountPendingReviews returns the number of unresolved review items
+func (s *StockService) CountPendingReviews(ctx context.Context) (int, error) {
+	return s.stockRepo.CountPendingReviews(ctx)
+}
+
+// CleanupResolvedReviews deletes resolved review items older than the cutoff
+func (s *StockService) CleanupResolvedReviews(ctx context.Context, before time.Time) (int64, error) {
+	return s.stockRepo.DeleteResolvedReviews(ctx, before)
+}
+
+// Fetch from FTP and parse both NASDAQ and other listed, return combined
+// slice and the creation time of the oldest file. Any failed source fails
+// the whole fetch: a partial catalog would read as thousands of delisted
+// tickers.
+func (s *StockService) FetchAllStocks(ctx context.Context) ([]models.Stock, time.Time, error) {
 	type source struct {
-		Path      string
-		Exchange  string
-		ParseFunc func(file io.ReadCloser) ([]models.Stock, error)
+		Exchange string
+		Layout   utils.SymbolLayout
 	}
 
 	sources := []source{
-		{
-			Path:      "/SymbolDirectory/nasdaqlisted.txt",
-			Exchange:  "NASDAQ",
-			ParseFunc: func(file io.ReadCloser) ([]models.Stock, error) {
-				return utils.ParseNasdaqListed(file)
-			},
-		},
-		{
-			Path:      "/SymbolDirectory/otherlisted.txt",
-			Exchange:  "OTHER",
-			ParseFunc: func(file io.ReadCloser) ([]models.Stock, error) {
-				return utils.ParseOtherListed(file)
-			},
-		},
+		{Exchange: "NASDAQ", Layout: utils.NasdaqListedLayout},
+		{Exchange: "OTHER", Layout: utils.OtherListedLayout},
 	}
 
-	var allStocks []models.Stock
+	var (
+		allStocks []models.Stock
+		oldest    time.Time
+		errs      []error
+	)
 
 	for _, src := range sources {
-		file, err := s.ftpClient.RetrieveFile(src.Path)
+		filePath := symbolDirectory + src.Layout.File
+		stocks, createdAt, err := s.fetchSource(ctx, filePath, src.Layout)
 		if err != nil {
-			log.Printf("Error fetching %s: %v", src.Path, err)
-			continue
-		}
-
-		stocks, err := src.ParseFunc(file)
-		file.Close()
-		if err != nil {
-			log.Printf("Error parsing %s: %v", src.Path, err)
+			slog.ErrorContext(ctx, "error fetching symbol file", "path", filePath, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", filePath, err))
 			continue
 		}
 
 		allStocks = append(allStocks, stocks...)
-		log.Printf("%s stocks fetched: %d", src.Exchange, len(stocks))
+		if oldest.IsZero() || createdAt.Before(oldest) {
+			oldest = createdAt
+		}
+		slog.InfoContext(ctx, "stocks fetched", "exchange", src.Exchange, "count", len(stocks), "file_created_at", createdAt)
 	}
 
-	return allStocks, nil
+	if len(errs) > 0 {
+		return nil, time.Time{}, fmt.Errorf("%w: %w", ErrSyncAborted, errors.Join(errs...))
+	}
+	return allStocks, oldest, nil
 }
 
-// Wrapper to fetch and save initial load
-func (s *StockService) InitializeStocks() error {
-	stocks, err := s.FetchAllStocks()
+// fetchSource retrieves and parses one symbol file inside an FTP span. The
+// transfer and parse are timed together.
+func (s *StockService) fetchSource(ctx context.Context, filePath string, layout utils.SymbolLayout) (_ []models.Stock, _ time.Time, err error) {
+	operation := path.Base(filePath)
+	ctx, span := telemetry.StartSpan(ctx, "FTP RETR "+operation, attribute.String("ftp.path", filePath))
+	start := time.Now()
+	defer func() {
+		metrics.ObserveUpstream(metrics.UpstreamFTP, operation, start, err)
+		telemetry.EndSpan(span, err)
+	}()
+
+	file, err := s.ftpClient.RetrieveFile(ctx, filePath)
 	if err != nil {
-		return err
+		return nil, time.Time{}, err
+	}
+	// Close reads the transfer's completion reply, so an aborted RETR fails
+	// the source even if what arrived happened to parse
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("completing transfer: %w", closeErr)
+		}
+	}()
+
+	stocks, createdAt, err := utils.ParseSymbols(file, layout)
+	if err != nil {
+		return nil, time.Time{}, err
 	}
-	return s.SaveStocksInitialLoad(stocks)
+	return stocks, createdAt, nil
 }
 
-// Wrapper to fetch and save with review
-func (s *StockService) FetchAndUpdateAllStocks() error {
-	stocks, err := s.FetchAllStocks()
+// Wrapper to fetch and save initial load
+func (s *StockService) InitializeStocks(ctx context.Context) error {
+	stocks, _, err := s.FetchAllStocks(ctx)
 	if err != nil {
 		return err
 	}
-	return s.SaveStocksWithReview(stocks)
+	return s.SaveStocksInitialLoad(ctx, stocks)
+}
+
+// Wrapper to fetch and save with review, recorded as a sync run
+func (s *StockService) FetchAndUpdateAllStocks(ctx context.Context) error {
+	return s.sync.record(ctx, CatalogStock, func(ctx context.Context, run *models.SyncRun) error {
+		stocks, createdAt, err := s.FetchAllStocks(ctx)
+		if err != nil {
+			return err
+		}
+		run.SourceCreatedAt = &createdAt
+		return s.SaveStocksWithReview(ctx, stocks, s.sync.guard(run))
+	})
 }
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"stock-talk-service/internal/config"
+	"stock-talk-service/internal/models"
+	"stock-talk-service/internal/policy"
+	"stock-talk-service/internal/repositories"
+	"time"
+)
+
+// Catalog names recorded on sync runs.
+const (
+	CatalogStock  = "stock"
+	CatalogCrypto = "crypto"
+)
+
+// ErrSyncAborted marks a sync stopped by a safety guard before anything was
+// written.
+var ErrSyncAborted = errors.New("sync aborted")
+
+// syncRecorder records each catalog sync in sync_run, enforces the diff
+// thresholds and carries the policy for applying changes.
+type syncRecorder struct {
+	runs   *repositories.SyncRunRepository
+	guards config.SyncGuards
+	policy *policy.Engine
+}
+
+// record wraps one sync. The run is stored as running first so a crash
+// mid-sync still leaves a trace, then finished with the outcome.
+func (r syncRecorder) record(ctx context.Context, catalog string, sync func(ctx context.Context, run *models.SyncRun) error) error {
+	id, err := randomHex(8)
+	if err != nil {
+		return err
+	}
+	run := &models.SyncRun{
+		Id:        id,
+		Catalog:   catalog,
+		Status:    models.SyncRunRunning,
+		StartedAt: time.Now(),
+	}
+	if err := r.runs.StartSyncRun(ctx, run); err != nil {
+		return fmt.Errorf("recording sync run: %w", err)
+	}
+
+	syncErr := sync(ctx, run)
+
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+	run.Status = models.SyncRunSucceeded
+	if syncErr != nil {
+		run.Status = models.SyncRunFailed
+		run.Reason = syncErr.Error()
+	}
+	// Record the outcome even when the job's context was cancelled
+	if err := r.runs.FinishSyncRun(context.WithoutCancel(ctx), run); err != nil {
+		slog.WarnContext(ctx, "failed to record sync run outcome", "catalog", catalog, "run", run.Id, "error", err)
+	}
+
+	if syncErr == nil {
+		attrs := []any{"catalog", catalog, "run", run.Id, "diff", run.Diff}
+		if run.SourceCreatedAt != nil {
+			attrs = append(attrs, "source_age", time.Since(*run.SourceCreatedAt).Round(time.Minute))
+		}
+		slog.InfoContext(ctx, "sync run succeeded", attrs...)
+	}
+	return syncErr
+}
+
+// guard returns the check passed to the review save. It stores the diff on
+// run and rejects it if it crosses a threshold.
+func (r syncRecorder) guard(run *models.SyncRun) func(models.SyncDiff) error {
+	return func(d models.SyncDiff) error {
+		run.Diff = d
+		return checkSyncDiff(r.guards, d)
+	}
+}
+
+// checkSyncDiff compares the diff against the thresholds, as a percentage of
+// the stored catalog. An empty catalog has nothing to protect.
+func checkSyncDiff(g config.SyncGuards, d models.SyncDiff) error {
+	if d.Existing == 0 {
+		return nil
+	}
+	if d.Fetched == 0 {
+		return fmt.Errorf("%w: fetched catalog is empty", ErrSyncAborted)
+	}
+
+	checks := []struct {
+		what  string
+		count int
+		max   float64
+	}{
+		{"missing", d.Missing, g.MaxMissingPercent},
+		{"added", d.Added, g.MaxAddedPercent},
+		{"changed", d.Changed, g.MaxChangedPercent},
+	}
+	for _, c := range checks {
+		pct := float64(c.count) * 100 / float64(d.Existing)
+		if pct > c.max {
+			return fmt.Errorf("%w: %d of %d entries %s (%.1f%%), above the %.1f%% limit",
+				ErrSyncAborted, c.count, d.Existing, c.what, pct, c.max)
+		}
+	}
+	return nil
+}
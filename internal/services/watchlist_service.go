@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"stock-talk-service/internal/models"
 	"stock-talk-service/internal/repositories"
 )
@@ -14,26 +15,26 @@ func NewWatchlistService(watchlistRepo *repositories.WatchlistRepository) *Watch
 }
 
 // GetWatchlistByID returns a watchlist by its ID.
-func (s *WatchlistService) GetWatchlistByID(id int) (*models.Watchlist, error) {
-    return s.watchlistRepo.GetWatchlistByID(id)
+func (s *WatchlistService) GetWatchlistByID(ctx context.Context, id int) (*models.Watchlist, error) {
+    return s.watchlistRepo.GetWatchlistByID(ctx, id)
 }
 
 // GetAllWatchlists returns all watchlists.
-func (s *WatchlistService) GetAllWatchlists() ([]models.Watchlist, error) {
-    return s.watchlistRepo.GetAllWatchlists()
+func (s *WatchlistService) GetAllWatchlists(ctx context.Context) ([]models.Watchlist, error) {
+    return s.watchlistRepo.GetAllWatchlists(ctx)
 }
 
 // CreateWatchlist creates a new watchlist.
-func (s *WatchlistService) CreateWatchlist(w *models.Watchlist, swi *[]string, cwi *[]string) error {
-    return s.watchlistRepo.CreateWatchlist(w, swi, cwi)
+func (s *WatchlistService) CreateWatchlist(ctx context.Context, w *models.Watchlist, swi *[]string, cwi *[]string) error {
+    return s.watchlistRepo.CreateWatchlist(ctx, w, swi, cwi)
 }
 
 // UpdateWatchlist updates an existing watchlist.
-func (s *WatchlistService) UpdateWatchlist(w *models.Watchlist) error {
-    return s.watchlistRepo.UpdateWatchlist(w)
+func (s *WatchlistService) UpdateWatchlist(ctx context.Context, w *models.Watchlist) error {
+    return s.watchlistRepo.UpdateWatchlist(ctx, w)
 }
 
 // DeleteWatchlist deletes a watchlist by ID.
-func (s *WatchlistService) DeleteWatchlist(id int64) error {
-    return s.watchlistRepo.DeleteWatchlist(id)
+func (s *WatchlistService) DeleteWatchlist(ctx context.Context, id int64) error {
+    return s.watchlistRepo.DeleteWatchlist(ctx, id)
 }
\ No newline at end of file
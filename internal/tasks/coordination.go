@@ -0,0 +1,98 @@
+package tasks
+
+import (
+	"context"
+	"log/slog"
+	"stock-talk-service/internal/lock"
+	"time"
+)
+
+const (
+	// followerPollInterval is how often a follower checks whether the leader
+	// has released the job lock.
+	followerPollInterval = 5 * time.Second
+
+	// completionWindow treats a completion this long before our own tick as
+	// the same run, absorbing clock skew and cron jitter between replicas.
+	completionWindow = time.Minute
+
+	// defaultLockTTL bounds lease locks for jobs configured without a timeout.
+	defaultLockTTL = time.Hour
+)
+
+// runExclusive runs job on whichever replica wins the lock. Replicas that
+// lose wait for the leader to release it, then reload their caches if it
+// completed or take over the run if it didn't.
+func (s *Scheduler) runExclusive(ctx context.Context, job Job, timeout time.Duration) error {
+	tick := time.Now()
+	ttl := timeout
+	if ttl <= 0 {
+		ttl = defaultLockTTL
+	}
+
+	l, ok, err := s.locker.TryAcquire(ctx, job.Name, ttl)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		slog.InfoContext(ctx, "job is running on another replica, waiting for it to finish")
+		if l, err = s.follow(ctx, job, ttl); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		// Release on a fresh context so a timed-out run still frees the lock.
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := l.Release(releaseCtx); err != nil {
+			slog.ErrorContext(ctx, "failed to release job lock", "error", err)
+		}
+	}()
+
+	last, err := s.locker.LastCompleted(ctx, job.Name)
+	if err != nil {
+		return err
+	}
+	if last.After(tick.Add(-completionWindow)) {
+		slog.InfoContext(ctx, "job already completed on another replica", "completed_at", last)
+		return reload(ctx, job)
+	}
+	if !ok {
+		slog.WarnContext(ctx, "job did not complete on the replica that held the lock, running it here")
+	}
+
+	if err := job.Run(ctx); err != nil {
+		return err
+	}
+	return s.locker.MarkCompleted(ctx, job.Name, time.Now())
+}
+
+// follow blocks until the leader releases the lock and returns it acquired,
+// so the caller can check whether the leader's run completed.
+func (s *Scheduler) follow(ctx context.Context, job Job, ttl time.Duration) (lock.Lock, error) {
+	ticker := time.NewTicker(followerPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		l, ok, err := s.locker.TryAcquire(ctx, job.Name, ttl)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return l, nil
+		}
+	}
+}
+
+func reload(ctx context.Context, job Job) error {
+	if job.Reload == nil {
+		return nil
+	}
+	return job.Reload(ctx)
+}
@@ -0,0 +1,65 @@
+package tasks
+
+import (
+	"context"
+	"log/slog"
+	"stock-talk-service/internal/config"
+	"stock-talk-service/internal/ftp_client"
+	"stock-talk-service/internal/services"
+	"time"
+)
+
+// StockSyncJob fetches the NASDAQ symbol directories and records review items.
+// Stopping it closes the FTP client, aborting a transfer a run that outlived
+// the shutdown wait is still reading.
+func StockSyncJob(stockService *services.StockService, ftpClient *ftp_client.FTPClient) Job {
+	return Job{
+		Name:      config.JobStockSync,
+		Run:       stockService.FetchAndUpdateAllStocks,
+		Reload:    stockService.ReloadStockCache,
+		Stop:      ftpClient.Close,
+		Exclusive: true,
+	}
+}
+
+// CryptoSyncJob reloads the coin mapping and records review items
+func CryptoSyncJob(cryptoService *services.CryptoService) Job {
+	return Job{
+		Name:      config.JobCryptoSync,
+		Run:       cryptoService.FetchAndUpdateAllCrypto,
+		Reload:    cryptoService.ReloadCryptoCache,
+		Exclusive: true,
+	}
+}
+
+// ValidationCacheRefreshJob refreshes the CoinGecko coin/currency lists. The
+// lists are cached per process, so every replica runs it.
+func ValidationCacheRefreshJob(cryptoService *services.CryptoService) Job {
+	return Job{
+		Name: config.JobValidationCacheRefresh,
+		Run:  cryptoService.RefreshValidationCache,
+	}
+}
+
+// CleanupJob deletes resolved review items older than retention
+func CleanupJob(stockService *services.StockService, cryptoService *services.CryptoService, retention time.Duration) Job {
+	return Job{
+		Name:      config.JobCleanup,
+		Exclusive: true,
+		Run: func(ctx context.Context) error {
+			before := time.Now().Add(-retention)
+
+			stocks, err := stockService.CleanupResolvedReviews(ctx, before)
+			if err != nil {
+				return err
+			}
+			crypto, err := cryptoService.CleanupResolvedReviews(ctx, before)
+			if err != nil {
+				return err
+			}
+
+			slog.InfoContext(ctx, "cleanup removed resolved review items", "stock", stocks, "crypto", crypto)
+			return nil
+		},
+	}
+}
@@ -1,32 +1,181 @@
 package tasks
 
 import (
-	"log"
-	"stock-talk-service/internal/services"
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"stock-talk-service/internal/config"
+	"stock-talk-service/internal/lock"
+	"stock-talk-service/internal/logging"
+	"stock-talk-service/internal/metrics"
+	"stock-talk-service/internal/telemetry"
+	"sync"
+	"time"
 
 	"github.com/robfig/cron/v3"
 )
 
-// ScheduleDailyUpdates sets up a cron job to fetch stocks at midnight every day
-func ScheduleDailyUpdates(stockService *services.StockService, cryptoService *services.CryptoService) {
-	c := cron.New()
+// Job is a unit of scheduled work. Run receives a context that is cancelled
+// when the job's timeout elapses or the scheduler is stopped. Stop, if set,
+// is called once during shutdown after running jobs have finished or the
+// shutdown wait has expired, and releases what a run may still hold.
+//
+// Exclusive jobs run on a single replica per schedule tick; the other
+// replicas wait for the leader to finish and then call Reload, if set.
+type Job struct {
+	Name      string
+	Run       func(ctx context.Context) error
+	Reload    func(ctx context.Context) error
+	Stop      func()
+	Exclusive bool
+}
+
+// Scheduler runs registered jobs on their own cron spec, timezone and timeout.
+type Scheduler struct {
+	cron    *cron.Cron
+	configs map[string]config.JobConfig
+	locker  lock.Locker
+	jobs    []Job
+
+	mu          sync.RWMutex
+	lastSuccess map[string]time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewScheduler creates a scheduler. locker may be nil for single-replica
+// deployments, in which case exclusive jobs simply run locally.
+func NewScheduler(configs map[string]config.JobConfig, locker lock.Locker) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		cron:    cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger))),
+		configs: configs,
+		locker:  locker,
+
+		lastSuccess: make(map[string]time.Time),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// Register adds a job using the config entry with the same name. Jobs without
+// a config entry or with Enabled=false are skipped.
+func (s *Scheduler) Register(job Job) error {
+	cfg, ok := s.configs[job.Name]
+	if !ok {
+		return fmt.Errorf("no schedule configured for job %q", job.Name)
+	}
+	if !cfg.Enabled {
+		slog.Info("job disabled, not scheduling", "job", job.Name)
+		return nil
+	}
+
+	spec := cfg.Spec
+	if cfg.Timezone != "" {
+		spec = fmt.Sprintf("CRON_TZ=%s %s", cfg.Timezone, cfg.Spec)
+	}
+
+	if _, err := s.cron.AddFunc(spec, func() { s.run(job, cfg.Timeout) }); err != nil {
+		return fmt.Errorf("scheduling job %q with spec %q: %w", job.Name, spec, err)
+	}
+	s.jobs = append(s.jobs, job)
+	slog.Info("job scheduled", "job", job.Name, "spec", spec)
+	return nil
+}
+
+// run executes a single job invocation with timeout and panic recovery.
+func (s *Scheduler) run(job Job, timeout time.Duration) {
+	ctx := logging.WithJob(s.ctx, job.Name)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-	_, err := c.AddFunc("0 0 * * *", func() {
-		log.Println("Running scheduled stock update...")
-		errS := stockService.FetchAndUpdateAllStocks()
-		if errS != nil {
-			log.Printf("Error fetching stocks: %v", errS)
+	var err error
+	ctx, span := telemetry.StartSpan(ctx, "job "+job.Name)
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+			metrics.ObserveJob(job.Name, metrics.OutcomePanic, time.Since(start))
+			slog.ErrorContext(ctx, "job panicked", "panic", r, "stack", string(debug.Stack()))
 		}
-		log.Println("Running scheduled crypto update...")
-		errC := cryptoService.FetchAndUpdateAllCrypto()
-		if errC != nil {
-			log.Printf("Error fetching crypto: %v", errC)
+	}()
+
+	slog.InfoContext(ctx, "running job")
+	run := job.Run
+	if job.Exclusive && s.locker != nil {
+		run = func(ctx context.Context) error { return s.runExclusive(ctx, job, timeout) }
+	}
+	if err = run(ctx); err != nil {
+		metrics.ObserveJob(job.Name, metrics.OutcomeFailure, time.Since(start))
+		slog.ErrorContext(ctx, "job failed", "duration", time.Since(start), "error", err)
+		return
+	}
+	metrics.ObserveJob(job.Name, metrics.OutcomeSuccess, time.Since(start))
+	slog.InfoContext(ctx, "job finished", "duration", time.Since(start))
+
+	s.mu.Lock()
+	s.lastSuccess[job.Name] = time.Now()
+	s.mu.Unlock()
+}
+
+// LastSuccess returns when each registered job last succeeded. For exclusive
+// jobs the leader's completion time is used if it is more recent, so a
+// freshly started replica still reports the last cluster-wide sync.
+func (s *Scheduler) LastSuccess(ctx context.Context) map[string]time.Time {
+	s.mu.RLock()
+	result := make(map[string]time.Time, len(s.lastSuccess))
+	for name, t := range s.lastSuccess {
+		result[name] = t
+	}
+	s.mu.RUnlock()
+
+	if s.locker == nil {
+		return result
+	}
+	for _, job := range s.jobs {
+		if !job.Exclusive {
+			continue
 		}
-	})
+		last, err := s.locker.LastCompleted(ctx, job.Name)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to read last job completion", "job", job.Name, "error", err)
+			continue
+		}
+		if last.After(result[job.Name]) {
+			result[job.Name] = last
+		}
+	}
+	return result
+}
 
-	if err != nil {
-		log.Fatalf("Failed to schedule daily update: %v", err)
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+// Stop prevents new runs, cancels running jobs and waits for them to return
+// or for ctx to expire, then calls each job's Stop hook.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.cancel()
+	stopped := s.cron.Stop()
+
+	var err error
+	select {
+	case <-stopped.Done():
+	case <-ctx.Done():
+		err = fmt.Errorf("waiting for running jobs: %w", ctx.Err())
 	}
 
-	c.Start()
+	for _, job := range s.jobs {
+		if job.Stop != nil {
+			job.Stop()
+		}
+	}
+	return err
 }
@@ -0,0 +1,89 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName identifies this service in traces.
+const ServiceName = "stock-talk-service"
+
+// Exporter values accepted by Setup.
+const (
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+)
+
+// Setup installs the global tracer provider and W3C trace context
+// propagation. With ExporterNone the global no-op provider is kept, so spans
+// cost next to nothing. The OTLP exporter reads the standard
+// OTEL_EXPORTER_OTLP_* env vars for endpoint, headers and TLS.
+func Setup(ctx context.Context, exporter string, sampleRatio float64) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	switch strings.ToLower(exporter) {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", exporter)
+	}
+
+	exp, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// StartSpan starts an internal span from the service tracer.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(ServiceName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartDBSpan starts a client span for a repository query.
+func StartDBSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return otel.Tracer(ServiceName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+	)
+}
+
+// EndSpan records err on span, if any, and ends it. Use it deferred with a
+// named error result: defer func() { telemetry.EndSpan(span, err) }().
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
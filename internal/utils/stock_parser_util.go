@@ -2,61 +2,190 @@ package utils
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"io"
 	"stock-talk-service/internal/models"
 	"strings"
+	"time"
 )
 
-func ParseNasdaqListed(r io.Reader) ([]models.Stock, error) {
-    scanner := bufio.NewScanner(r)
-    var stocks []models.Stock
-    var lines []string
-
-    for scanner.Scan() {
-        lines = append(lines, scanner.Text())
-    }
-
-    if err := scanner.Err(); err != nil {
-        return nil, err
-    }
-
-    for i := 0; i < len(lines)-1; i++ {
-        line := lines[i]
-        if strings.Contains(line, "|") && !strings.HasPrefix(line, "Symbol|") {
-            parts := strings.Split(line, "|")
-            if len(parts) >= 2 {
-                stocks = append(stocks, models.Stock{Ticker: parts[0], Name: parts[1]})
-            }
-        }
-    }
-
-    return stocks, nil
+// NASDAQ Trader symbol directory files are pipe-delimited with a header row
+// and end with a "File Creation Time: MMDDYYYYHH:MM" trailer. A file
+// without the trailer was cut off mid-transfer.
+const (
+	trailerPrefix = "File Creation Time:"
+	trailerLayout = "0102200615:04"
+)
+
+// SymbolLayout maps one symbol directory file's columns by header name, so
+// column order may change and new columns may be added upstream.
+type SymbolLayout struct {
+	File         string
+	SymbolColumn string
+	NameColumn   string
+	// Required lists other columns the header must contain.
+	Required []string
 }
 
+// Layouts for the SymbolDirectory files we know about.
+var (
+	NasdaqListedLayout = SymbolLayout{
+		File:         "nasdaqlisted.txt",
+		SymbolColumn: "Symbol",
+		NameColumn:   "Security Name",
+		Required:     []string{"Market Category", "Test Issue", "ETF"},
+	}
+	OtherListedLayout = SymbolLayout{
+		File:         "otherlisted.txt",
+		SymbolColumn: "ACT Symbol",
+		NameColumn:   "Security Name",
+		Required:     []string{"Exchange", "Test Issue", "ETF"},
+	}
+	MutualFundsLayout = SymbolLayout{
+		File:         "mfundslist.txt",
+		SymbolColumn: "Fund Symbol",
+		NameColumn:   "Fund Name",
+	}
+	BondsLayout = SymbolLayout{
+		File:         "bondslist.txt",
+		SymbolColumn: "Symbol",
+		NameColumn:   "Security Name",
+	}
+)
+
+// ErrTruncatedFile reports a symbol file that ended before its trailer.
+var ErrTruncatedFile = errors.New("symbol file truncated: missing File Creation Time trailer")
 
-func ParseOtherListed(r io.Reader) ([]models.Stock, error) {
-    scanner := bufio.NewScanner(r)
-    var stocks []models.Stock
-    var lines []string
+// nasdaqTimezone is where the trailer timestamps are written.
+var nasdaqTimezone = func() *time.Location {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}()
+
+// SymbolRecord is one data row of a symbol file.
+type SymbolRecord struct {
+	Line    int
+	columns map[string]int
+	fields  []string
+}
+
+// Get returns the value in the named column, or "" if the file has no such
+// column.
+func (r SymbolRecord) Get(column string) string {
+	i, ok := r.columns[column]
+	if !ok {
+		return ""
+	}
+	return r.fields[i]
+}
 
-    for scanner.Scan() {
-        lines = append(lines, scanner.Text())
-    }
+// ScanSymbolFile streams a symbol file, calling fn for each data row in
+// order, and returns the file creation time from the trailer. It checks the
+// header has the layout's columns, that every row has as many fields as the
+// header, and that the trailer is the last line. An error from fn stops the
+// scan and is returned as-is.
+func ScanSymbolFile(r io.Reader, layout SymbolLayout, fn func(SymbolRecord) error) (time.Time, error) {
+	scanner := bufio.NewScanner(r)
+	var (
+		columns   map[string]int
+		createdAt time.Time
+		lineNo    int
+	)
 
-    if err := scanner.Err(); err != nil {
-        return nil, err
-    }
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if !createdAt.IsZero() {
+			return time.Time{}, fmt.Errorf("%s line %d: data after the File Creation Time trailer", layout.File, lineNo)
+		}
 
-    // Exclude the last line
-    for i := 0; i < len(lines)-1; i++ {
-        line := lines[i]
-        if strings.Contains(line, "|") && !strings.HasPrefix(line, "ACT Symbol|") {
-            parts := strings.Split(line, "|")
-            if len(parts) >= 2 {
-                stocks = append(stocks, models.Stock{Ticker: parts[0], Name: parts[1]})
-            }
-        }
-    }
+		fields := strings.Split(line, "|")
+		switch {
+		case columns == nil:
+			var err error
+			if columns, err = mapHeader(fields, layout); err != nil {
+				return time.Time{}, fmt.Errorf("%s: %w", layout.File, err)
+			}
+		case strings.HasPrefix(line, trailerPrefix):
+			t, err := parseTrailer(fields[0])
+			if err != nil {
+				return time.Time{}, fmt.Errorf("%s line %d: %w", layout.File, lineNo, err)
+			}
+			createdAt = t
+		case len(fields) != len(columns):
+			return time.Time{}, fmt.Errorf("%s line %d: expected %d fields, got %d", layout.File, lineNo, len(columns), len(fields))
+		default:
+			if err := fn(SymbolRecord{Line: lineNo, columns: columns, fields: fields}); err != nil {
+				return time.Time{}, err
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
+	if columns == nil {
+		return time.Time{}, fmt.Errorf("%s: file is empty", layout.File)
+	}
+	if createdAt.IsZero() {
+		return time.Time{}, fmt.Errorf("%s: %w", layout.File, ErrTruncatedFile)
+	}
+	return createdAt, nil
+}
+
+// ParseSymbols collects a symbol file into stocks, taking the ticker and name
+// from the layout's columns.
+func ParseSymbols(r io.Reader, layout SymbolLayout) ([]models.Stock, time.Time, error) {
+	var stocks []models.Stock
+	createdAt, err := ScanSymbolFile(r, layout, func(rec SymbolRecord) error {
+		stocks = append(stocks, models.Stock{
+			Ticker: rec.Get(layout.SymbolColumn),
+			Name:   rec.Get(layout.NameColumn),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return stocks, createdAt, nil
+}
+
+// mapHeader indexes the header by column name and checks the layout's
+// columns are all present.
+func mapHeader(fields []string, layout SymbolLayout) (map[string]int, error) {
+	columns := make(map[string]int, len(fields))
+	for i, name := range fields {
+		if _, dup := columns[name]; dup {
+			return nil, fmt.Errorf("unexpected header: duplicate column %q", name)
+		}
+		columns[name] = i
+	}
+
+	var missing []string
+	for _, name := range append([]string{layout.SymbolColumn, layout.NameColumn}, layout.Required...) {
+		if _, ok := columns[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("unexpected header %q: missing columns %q", fields, missing)
+	}
+	return columns, nil
+}
 
-    return stocks, nil
+// parseTrailer reads "File Creation Time: 1019202608:32".
+func parseTrailer(field string) (time.Time, error) {
+	value := strings.TrimSpace(strings.TrimPrefix(field, trailerPrefix))
+	t, err := time.ParseInLocation(trailerLayout, value, nasdaqTimezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid File Creation Time %q", value)
+	}
+	return t, nil
 }
@@ -1,21 +1,25 @@
 package validation
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"stock-talk-service/internal/apperrors"
 	"stock-talk-service/internal/config"
+	"stock-talk-service/internal/metrics"
 	"strings"
 	"sync"
 )
 
+// httpClient records CoinGecko list fetches alongside the service's calls
+var httpClient = metrics.NewUpstreamClient(metrics.UpstreamCoinGecko)
+
 var (
 	coinIDCache      = make(map[string]bool)
 	vsCurrencyCache  = make(map[string]bool)
-	coinIDOnce       sync.Once
-	vsCurrencyOnce   sync.Once
-	coinIDMutex      sync.Mutex
-	vsCurrencyMutex  sync.Mutex
+	coinIDMutex      sync.RWMutex
+	vsCurrencyMutex  sync.RWMutex
 )
 
 type ValidationResult struct {
@@ -25,69 +29,116 @@ type ValidationResult struct {
 	InvalidVsCurrencies []string
 }
 
-// Fetch valid coin IDs once and cache
-func getValidCoinIDs(cfg *config.Config) (map[string]bool, error) {
-	var err error
-	coinIDOnce.Do(func() {
-		resp, e := http.Get(cfg.CoingeckoBaseUrl + "/coins/list")
-		if e != nil {
-			err = e
-			return
-		}
-		defer resp.Body.Close()
+// Fetch valid coin IDs on first use and cache
+func getValidCoinIDs(ctx context.Context, cfg *config.Config) (map[string]bool, error) {
+	coinIDMutex.RLock()
+	cache := coinIDCache
+	coinIDMutex.RUnlock()
+	if len(cache) > 0 {
+		return cache, nil
+	}
+	if err := refreshCoinIDs(ctx, cfg); err != nil {
+		return nil, err
+	}
+	coinIDMutex.RLock()
+	defer coinIDMutex.RUnlock()
+	return coinIDCache, nil
+}
 
-		var coins []struct {
-			ID string `json:"id"`
-		}
-		if e := json.NewDecoder(resp.Body).Decode(&coins); e != nil {
-			err = e
-			return
-		}
+// Fetch valid vs_currencies on first use and cache
+func getValidVsCurrencies(ctx context.Context, cfg *config.Config) (map[string]bool, error) {
+	vsCurrencyMutex.RLock()
+	cache := vsCurrencyCache
+	vsCurrencyMutex.RUnlock()
+	if len(cache) > 0 {
+		return cache, nil
+	}
+	if err := refreshVsCurrencies(ctx, cfg); err != nil {
+		return nil, err
+	}
+	vsCurrencyMutex.RLock()
+	defer vsCurrencyMutex.RUnlock()
+	return vsCurrencyCache, nil
+}
 
-		coinIDMutex.Lock()
-		defer coinIDMutex.Unlock()
-		for _, coin := range coins {
-			coinIDCache[coin.ID] = true
-		}
-	})
-	return coinIDCache, err
+// getList fetches one CoinGecko list into out. Transport failures, non-2xx
+// replies and undecodable bodies are all upstream errors.
+func getList(ctx context.Context, cfg *config.Config, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", cfg.CoingeckoBaseUrl+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return apperrors.Upstream(metrics.UpstreamCoinGecko, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return apperrors.Upstream(metrics.UpstreamCoinGecko, fmt.Errorf("GET %s: status %d", path, resp.StatusCode))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return apperrors.Upstream(metrics.UpstreamCoinGecko, fmt.Errorf("decoding %s: %w", path, err))
+	}
+	return nil
 }
 
-// Fetch valid vs_currencies once and cache
-func getValidVsCurrencies(cfg *config.Config) (map[string]bool, error) {
-	var err error
-	vsCurrencyOnce.Do(func() {
-		resp, e := http.Get(cfg.CoingeckoBaseUrl + "/simple/supported_vs_currencies")
-		if e != nil {
-			err = e
-			return
-		}
-		defer resp.Body.Close()
+func refreshCoinIDs(ctx context.Context, cfg *config.Config) error {
+	var coins []struct {
+		ID string `json:"id"`
+	}
+	if err := getList(ctx, cfg, "/coins/list", &coins); err != nil {
+		return err
+	}
 
-		var currencies []string
-		if e := json.NewDecoder(resp.Body).Decode(&currencies); e != nil {
-			err = e
-			return
-		}
+	cache := make(map[string]bool, len(coins))
+	for _, coin := range coins {
+		cache[coin.ID] = true
+	}
 
-		vsCurrencyMutex.Lock()
-		defer vsCurrencyMutex.Unlock()
-		for _, cur := range currencies {
-			vsCurrencyCache[strings.ToLower(cur)] = true
-		}
-	})
-	return vsCurrencyCache, err
+	coinIDMutex.Lock()
+	coinIDCache = cache
+	coinIDMutex.Unlock()
+	return nil
+}
+
+func refreshVsCurrencies(ctx context.Context, cfg *config.Config) error {
+	var currencies []string
+	if err := getList(ctx, cfg, "/simple/supported_vs_currencies", &currencies); err != nil {
+		return err
+	}
+
+	cache := make(map[string]bool, len(currencies))
+	for _, cur := range currencies {
+		cache[strings.ToLower(cur)] = true
+	}
+
+	vsCurrencyMutex.Lock()
+	vsCurrencyCache = cache
+	vsCurrencyMutex.Unlock()
+	return nil
+}
+
+// RefreshCaches re-fetches both lists, keeping the previous cache on error
+func RefreshCaches(ctx context.Context, cfg *config.Config) error {
+	if err := refreshCoinIDs(ctx, cfg); err != nil {
+		return fmt.Errorf("error refreshing coin IDs: %w", err)
+	}
+	if err := refreshVsCurrencies(ctx, cfg); err != nil {
+		return fmt.Errorf("error refreshing vs currencies: %w", err)
+	}
+	return nil
 }
 
 // Validate crypto input values
-func ValidateCryptoInputs(cfg *config.Config, coinIDs, vsCurrencies []string) (ValidationResult, error) {
-	coinCache, err := getValidCoinIDs(cfg)
+func ValidateCryptoInputs(ctx context.Context, cfg *config.Config, coinIDs, vsCurrencies []string) (ValidationResult, error) {
+	coinCache, err := getValidCoinIDs(ctx, cfg)
 	if err != nil {
-		return ValidationResult{}, fmt.Errorf("error fetching coin IDs: %w", err)
+		return ValidationResult{}, apperrors.Upstream(metrics.UpstreamCoinGecko, fmt.Errorf("error fetching coin IDs: %w", err))
 	}
-	vsCache, err := getValidVsCurrencies(cfg)
+	vsCache, err := getValidVsCurrencies(ctx, cfg)
 	if err != nil {
-		return ValidationResult{}, fmt.Errorf("error fetching vs currencies: %w", err)
+		return ValidationResult{}, apperrors.Upstream(metrics.UpstreamCoinGecko, fmt.Errorf("error fetching vs currencies: %w", err))
 	}
 
 	var (
@@ -122,21 +173,32 @@ func ValidateCryptoInputs(cfg *config.Config, coinIDs, vsCurrencies []string) (V
 	}, nil
 }
 
-// Validate and raise errors like FastAPI's HTTPException
-func ValidateAndRaise(cfg *config.Config, coinIDs, vsCurrencies []string) (ValidationResult, error) {
-	result, err := ValidateCryptoInputs(cfg, coinIDs, vsCurrencies)
+// InvalidInputs lists the rejected values of a validation error.
+type InvalidInputs struct {
+	InvalidCoinIDs      []string `json:"invalid_coin_ids,omitempty"`
+	InvalidVsCurrencies []string `json:"invalid_vs_currencies,omitempty"`
+}
+
+// ValidateAndRaise validates the inputs and returns a validation error when
+// nothing usable is left. Partially valid input passes; the caller reports
+// the invalid remainder alongside its results.
+func ValidateAndRaise(ctx context.Context, cfg *config.Config, coinIDs, vsCurrencies []string) (ValidationResult, error) {
+	result, err := ValidateCryptoInputs(ctx, cfg, coinIDs, vsCurrencies)
 	if err != nil {
 		return result, err
 	}
 
-	if len(result.ValidCoinIDs) == 0 && len(result.ValidVsCurrencies) == 0 {
-		return result, fmt.Errorf("no valid coin_id(s) or vs_currency(ies) provided. Invalid coin_ids: %v, invalid vs_currencies: %v", result.InvalidCoinIDs, result.InvalidVsCurrencies)
-	}
-	if len(result.ValidCoinIDs) == 0 {
-		return result, fmt.Errorf("no valid coin_id(s) provided. Invalid: %v", result.InvalidCoinIDs)
+	details := InvalidInputs{
+		InvalidCoinIDs:      result.InvalidCoinIDs,
+		InvalidVsCurrencies: result.InvalidVsCurrencies,
 	}
-	if len(result.ValidVsCurrencies) == 0 {
-		return result, fmt.Errorf("no valid vs_currency(ies) provided. Invalid: %v", result.InvalidVsCurrencies)
+	switch {
+	case len(result.ValidCoinIDs) == 0 && len(result.ValidVsCurrencies) == 0:
+		return result, apperrors.Validation("no valid coin_id(s) or vs_currency(ies) provided", details)
+	case len(result.ValidCoinIDs) == 0:
+		return result, apperrors.Validation("no valid coin_id(s) provided", details)
+	case len(result.ValidVsCurrencies) == 0:
+		return result, apperrors.Validation("no valid vs_currency(ies) provided", details)
 	}
 
 	return result, nil
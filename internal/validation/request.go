@@ -0,0 +1,147 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"stock-talk-service/internal/apperrors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// FieldError describes one rejected request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// watchlistNamePattern allows letters, digits, spaces and light punctuation.
+var watchlistNamePattern = regexp.MustCompile(`^[\p{L}\p{N} _\-'.&()]+$`)
+
+// RegisterBindings adds the custom rules used by the request models to gin's
+// validator and reports fields by their JSON/query name. Call it once at
+// startup, before serving.
+func RegisterBindings() error {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return errors.New("unexpected gin validator engine")
+	}
+
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		for _, tag := range []string{"json", "form"} {
+			if name, _, _ := strings.Cut(f.Tag.Get(tag), ","); name != "" && name != "-" {
+				return name
+			}
+		}
+		return f.Name
+	})
+
+	rules := map[string]validator.Func{
+		"days":           validateDays,
+		"watchlist_name": validateWatchlistName,
+	}
+	for tag, fn := range rules {
+		if err := v.RegisterValidation(tag, fn); err != nil {
+			return fmt.Errorf("registering %s rule: %w", tag, err)
+		}
+	}
+	return nil
+}
+
+// validateDays accepts a positive integer or "max", as CoinGecko does.
+func validateDays(fl validator.FieldLevel) bool {
+	s := fl.Field().String()
+	if s == "max" {
+		return true
+	}
+	n, err := strconv.Atoi(s)
+	return err == nil && n > 0
+}
+
+func validateWatchlistName(fl validator.FieldLevel) bool {
+	s := fl.Field().String()
+	return strings.TrimSpace(s) != "" && watchlistNamePattern.MatchString(s)
+}
+
+// BindingError converts an error from gin's ShouldBind* into a validation
+// error with one entry per offending field.
+func BindingError(err error) *apperrors.Error {
+	var (
+		verrs   validator.ValidationErrors
+		typeErr *json.UnmarshalTypeError
+		numErr  *strconv.NumError
+		sizeErr *http.MaxBytesError
+	)
+	switch {
+	case errors.As(err, &sizeErr):
+		return apperrors.TooLarge(sizeErr.Limit)
+	case errors.As(err, &verrs):
+		fields := make([]FieldError, 0, len(verrs))
+		for _, fe := range verrs {
+			fields = append(fields, FieldError{
+				Field:   fieldPath(fe),
+				Rule:    fe.Tag(),
+				Message: fieldMessage(fe),
+			})
+		}
+		return apperrors.Validation("request validation failed", fields)
+	case errors.As(err, &typeErr):
+		return apperrors.Validation("request validation failed", []FieldError{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Message: "must be of type " + typeErr.Type.String(),
+		}})
+	case errors.As(err, &numErr):
+		return apperrors.Validation("request validation failed", []FieldError{{
+			Rule:    "type",
+			Message: fmt.Sprintf("%q is not a number", numErr.Num),
+		}})
+	default:
+		return apperrors.Validation("malformed request", map[string]string{"reason": err.Error()})
+	}
+}
+
+// fieldPath drops the struct name so "CryptoPriceRequest.coin_ids[0]"
+// becomes "coin_ids[0]".
+func fieldPath(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if _, rest, ok := strings.Cut(ns, "."); ok {
+		return rest
+	}
+	return ns
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	collection := fe.Kind() == reflect.Slice || fe.Kind() == reflect.Map
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		if collection {
+			return "must contain at least " + fe.Param() + " item(s)"
+		}
+		return "must be at least " + fe.Param() + " characters"
+	case "max":
+		if collection {
+			return "must contain at most " + fe.Param() + " items"
+		}
+		return "must be at most " + fe.Param() + " characters"
+	case "oneof":
+		return "must be one of: " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "alpha":
+		return "must contain only letters"
+	case "days":
+		return `must be a positive integer or "max"`
+	case "watchlist_name":
+		return "must contain only letters, digits, spaces and - _ ' . & ( )"
+	default:
+		return "failed the " + fe.Tag() + " rule"
+	}
+}